@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/phuslu/log"
+	"strings"
+)
+
+type (
+	Severity int
+
+	// NotificationKind is a kind of the admin notifications. Admin receives all the kinds if none is specified.
+	NotificationKind string
+
+	// Admin is the user who receives notifications of the specified kinds with severity not less than MinSeverity.
+	Admin struct {
+		User        string             `json:"user"`
+		MinSeverity string             `json:"severity,omitempty"`
+		Kinds       []NotificationKind `json:"kinds,omitempty"`
+		id          int64
+		severity    Severity
+	}
+)
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+const (
+	// NotifySummary is sent after every fetch with new images or errors
+	NotifySummary NotificationKind = "summary"
+	// NotifyReport is the daily report
+	NotifyReport NotificationKind = "report"
+	// NotifyFailure is sent on every failed page or image
+	NotifyFailure NotificationKind = "failure"
+	// NotifySuggestion is sent when a user suggests an image, it has the review buttons
+	NotifySuggestion NotificationKind = "suggestion"
+	// NotifyDirect is the message sent by 'send-admin-msg' command, all the admins receive it
+	NotifyDirect NotificationKind = "direct"
+)
+
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "", "info":
+		return SeverityInfo, nil
+	case "warning", "warn":
+		return SeverityWarning, nil
+	case "error":
+		return SeverityError, nil
+	}
+	return 0, fmt.Errorf("unknown severity: '%s'", s)
+}
+
+func (a *Admin) validate() error {
+	if a.User == "" {
+		return errors.New("admin user is empty")
+	}
+	var err error
+	a.severity, err = ParseSeverity(a.MinSeverity)
+	if err != nil {
+		return fmt.Errorf("admin '%s': %w", a.User, err)
+	}
+	for _, k := range a.Kinds {
+		switch k {
+		case NotifySummary, NotifyReport, NotifyFailure, NotifySuggestion:
+		default:
+			return fmt.Errorf("admin '%s': unknown notification kind '%s'", a.User, k)
+		}
+	}
+	return nil
+}
+
+// Subscribed checks if the admin wants to receive the notification.
+func (a *Admin) Subscribed(kind NotificationKind, severity Severity) bool {
+	if kind == NotifyDirect {
+		return true
+	}
+	if severity < a.severity {
+		return false
+	}
+	if len(a.Kinds) == 0 {
+		return true
+	}
+	for _, k := range a.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Gorobei) initAdmins() error {
+	for _, a := range g.admins {
+		id, name, err := ParseChat(a.User)
+		if err != nil {
+			return fmt.Errorf("admin '%s': %w", a.User, err)
+		}
+		if id == 0 {
+			id, err = g.d.ReadUserId(strings.TrimPrefix(name, "@"))
+		}
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				log.Error().Err(err).Str("admin", a.User).Msg("cannot obtain admin user ID. Admin notifications will be disabled.")
+				continue
+			}
+			return err
+		}
+		a.id = id
+	}
+	return nil
+}
+
+// Notify routes the message to the admins subscribed to the kind and severity.
+func (g *Gorobei) Notify(kind NotificationKind, severity Severity, message *MessageBuilder) error {
+	return g.notify(kind, severity, func(id int64) error {
+		return g.tg.SendMessage("", id, message)
+	})
+}
+
+func (g *Gorobei) notify(kind NotificationKind, severity Severity, send func(id int64) error) error {
+	var lastErr error
+	for _, a := range g.admins {
+		if a.id == 0 || !a.Subscribed(kind, severity) {
+			continue
+		}
+		err := send(a.id)
+		if err != nil {
+			log.Error().Err(err).Str("admin", a.User).Msg("cannot send admin message")
+			lastErr = err
+		}
+	}
+	return lastErr
+}
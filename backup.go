@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/phuslu/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Maintenance is the database housekeeping done by the daemon, zero intervals disable the tasks.
+type Maintenance struct {
+	// BackupDir is the directory periodic full backups are written to
+	BackupDir      string
+	BackupInterval time.Duration
+	// BackupKeep is the number of the latest backups kept in BackupDir
+	BackupKeep int
+	GcInterval time.Duration
+}
+
+const (
+	backupPrefix = "gorobei-"
+	backupExt    = ".bak"
+)
+
+var errNoBackups = errors.New("backups are not supported by the storage")
+
+// Backup writes the keys changed after since version to the file in the native format of the storage.
+// It returns the version to pass as since for the next incremental backup, since 0 is a full backup.
+func (d *Db) Backup(path string, since uint64) (uint64, error) {
+	backuper, ok := d.b.(kvBackuper)
+	if !ok {
+		return 0, errNoBackups
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	version, err := backuper.Backup(f, since)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	// the backup is never seen half-written
+	return version, os.Rename(tmp, path)
+}
+
+// Restore replaces the database with the backups. The full backup goes first followed by the incremental ones
+// in the order they were taken. The schema is migrated if the backup is older than the current one.
+func (d *Db) Restore(paths []string) error {
+	backuper, ok := d.b.(kvBackuper)
+	if !ok {
+		return errNoBackups
+	}
+	files := make([]*os.File, 0, len(paths))
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	// fail before the database is dropped if any of the files is missing
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	err := d.b.DropAll()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		err = backuper.Load(f)
+		if err != nil {
+			return fmt.Errorf("cannot load backup '%s': %w", f.Name(), err)
+		}
+	}
+	return d.Migrate()
+}
+
+// RunGC reclaims the space of the deleted and expired keys if the storage needs it.
+func (d *Db) RunGC() error {
+	if c, ok := d.b.(kvCollector); ok {
+		return c.RunGC()
+	}
+	return nil
+}
+
+// BackupDb writes the backup to the file and prints the version for the next incremental backup.
+func (g *Gorobei) BackupDb(path string, since uint64) error {
+	version, err := g.d.Backup(path, since)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("path", path).Uint64("since", since).Uint64("next_since", version).Msg("database backed up")
+	fmt.Println(version)
+	return nil
+}
+
+// RestoreDb replaces the database with the backups.
+func (g *Gorobei) RestoreDb(paths []string) error {
+	err := g.d.Restore(paths)
+	if err != nil {
+		return err
+	}
+	log.Info().Strs("paths", paths).Msg("database restored")
+	return nil
+}
+
+// runMaintenance backs up the database and collects the value log garbage till stop is closed.
+func (g *Gorobei) runMaintenance(m *Maintenance, stop <-chan struct{}) {
+	var backup, gc <-chan time.Time
+	if m.BackupDir != "" && m.BackupInterval > 0 {
+		t := time.NewTicker(m.BackupInterval)
+		defer t.Stop()
+		backup = t.C
+	}
+	if m.GcInterval > 0 {
+		t := time.NewTicker(m.GcInterval)
+		defer t.Stop()
+		gc = t.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-backup:
+			err := g.rotateBackups(m.BackupDir, m.BackupKeep)
+			if err != nil {
+				log.Error().Err(err).Str("dir", m.BackupDir).Msg("cannot back up database")
+			}
+		case <-gc:
+			err := g.d.RunGC()
+			if err != nil {
+				log.Error().Err(err).Msg("cannot collect value log garbage")
+			}
+		}
+	}
+}
+
+// rotateBackups writes the full backup to the directory and removes the oldest ones, so keep backups remain.
+func (g *Gorobei) rotateBackups(dir string, keep int) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	name := backupPrefix + g.clock.Now().UTC().Format("20060102T150405") + backupExt
+	path := filepath.Join(dir, name)
+	_, err = g.d.Backup(path, 0)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("path", path).Msg("database backed up")
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*"+backupExt))
+	if err != nil {
+		return err
+	}
+	// the names sort in the order the backups were taken
+	sort.Strings(backups)
+	for len(backups) > keep {
+		err = os.Remove(backups[0])
+		if err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorobei/clock"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDb_BackupRestore(t *testing.T) {
+	initLog(false)
+	dir := t.TempDir()
+	d, err := OpenDb("badger://" + filepath.Join(dir, "db"))
+	require.NoError(t, err)
+	defer d.Close()
+	fillTestDb(t, d)
+
+	full := filepath.Join(dir, "full.bak")
+	since, err := d.Backup(full, 0)
+	require.NoError(t, err)
+	require.NotZero(t, since)
+	require.NoError(t, d.StoreChatPause(-300, "kicked"))
+	require.NoError(t, d.DeleteChatPause(-200))
+	inc := filepath.Join(dir, "inc.bak")
+	_, err = d.Backup(inc, since)
+	require.NoError(t, err)
+	_, err = os.Stat(inc + ".tmp")
+	require.True(t, os.IsNotExist(err))
+
+	d2, err := OpenDb("badger://" + filepath.Join(dir, "restore_db"))
+	require.NoError(t, err)
+	defer d2.Close()
+	require.NoError(t, d2.StoreChatPause(-400, "kicked"))
+
+	// missing file, the database is kept
+	require.Error(t, d2.Restore([]string{full, filepath.Join(dir, "missing.bak")}))
+	_, err = d2.ReadChatPause(-400)
+	require.NoError(t, err)
+
+	require.NoError(t, d2.Restore([]string{full}))
+	_, err = d2.ReadChatPause(-400)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = d2.ReadChatPause(-200)
+	require.NoError(t, err)
+
+	require.NoError(t, d2.Restore([]string{full, inc}))
+	assert.Equal(t, dbKeys(t, d), dbKeys(t, d2))
+	_, err = d2.ReadChatPause(-200)
+	require.ErrorIs(t, err, ErrNotFound)
+	r, err := d2.ReadImage("http://img/1.jpg?a=1&b=2")
+	require.NoError(t, err)
+	assert.Equal(t, ImagePosted, r.Status)
+	version, err := d2.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	require.NoError(t, d2.RunGC())
+}
+
+func TestGorobei_rotateBackups(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	// the in-memory storage has no backups
+	d, err := OpenDb("badger://" + filepath.Join(t.TempDir(), "db"))
+	require.NoError(t, err)
+	defer d.Close()
+	g.d = d
+	clk := g.clock.(*clock.TestClock)
+	clk.Tm = time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	dir := filepath.Join(t.TempDir(), "backups")
+	// not a backup, never removed
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, g.rotateBackups(dir, 2))
+		clk.Add(24 * time.Hour)
+	}
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"gorobei-20220502T100000.bak", "gorobei-20220503T100000.bak", "notes.txt"}, names)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/alecthomas/kong"
 	"strings"
+	"time"
 )
 
 const (
@@ -13,27 +14,44 @@ const (
 	CmdSendChatImg  = "send-chat-img"
 	CmdSendAdminMsg = "send-admin-msg"
 	CmdForgetImg    = "forget-img"
-	CmdReport		= "report"
+	CmdReport       = "report"
+	CmdDeletePost   = "delete-post"
+	CmdEditCaption  = "edit-caption"
+	CmdResumeChat   = "resume-chat"
+	CmdHistory      = "history"
+	CmdDbExport     = "db export"
+	CmdDbImport     = "db import"
+	CmdDbBackup     = "db backup"
+	CmdDbRestore    = "db restore"
+	CmdDbPrune      = "db prune"
+	CmdDaemon       = "daemon"
 )
 
 type CLI struct {
-	Admin   string `help:"Admin user. The Bot notifies admin about errors if this option is set."`
-	Chat    string `help:"Chat name." default:"@gorobei_posts"`
-	Token   string `help:"Telegram Bot token." env:"GOROBEI_BOT_TOKEN"`
-	Verbose bool   `help:"Print verbose logs."`
-	Fetch   struct {
+	Admin         []string      `help:"Admin users: names, '@name', 't.me/name' links or numeric IDs. The Bot notifies admins about errors if this option is set. Admins with notification preferences are set in the config file."`
+	Chat          string        `help:"Chat: '@name', 't.me/name' link or numeric ID, e.g. -1001234567890." default:"@gorobei_posts"`
+	Config        string        `help:"Path to the config file describing sources and their destination chats." type:"path"`
+	Token         string        `help:"Telegram Bot token." env:"GOROBEI_BOT_TOKEN"`
+	ApiUrl        string        `help:"Bot API server URL, e.g. self-hosted one." env:"GOROBEI_API_URL"`
+	Proxy         string        `help:"Proxy for the Telegram traffic: socks5://host:port or http://host:port." env:"GOROBEI_PROXY"`
+	Timeout       time.Duration `help:"Telegram request timeout." default:"60s"`
+	Verbose       bool          `help:"Print verbose logs."`
+	PersistLimits bool          `help:"Keep rate limiter state in the limits file, so the runs and the parallel commands share the chat limits."`
+	LimitsFile    string        `help:"Rate limiter state file, it is locked while the state is updated." default:"./gorobei_limits.json" type:"path"`
+	DbUrl         string        `name:"db" help:"Database URL: 'badger://dir', 'bolt://file' or 'memory://' keeping nothing on exit." default:"badger://./gorobei_db" env:"GOROBEI_DB"`
+	Fetch         struct {
 		Limit int    `help:"Stop after processing of '--limit' number of items. Default is 0 which means process all images."`
 		Url   string `arg:"" help:"Url to fetch data from."`
 	} `cmd:"" help:"Parse the specified page content and fetch images."`
 	SendMsg struct {
-		Username string `help:"User to whom message is sent." required:""`
+		Username string `help:"User or chat to whom message is sent: name, '@name', 't.me/name' link or numeric ID." required:""`
 		Message  string `help:"Message text." default:"test message"`
 	} `cmd:"" help:"Send message to specified user."`
 	SendChatMsg struct {
 		Message string `help:"Message text." default:"test message"`
 	} `cmd:"" help:"Send message to to default chat."`
 	SendImg struct {
-		Username  string `help:"User to whom message is sent." required:""`
+		Username  string `help:"User or chat to whom message is sent: name, '@name', 't.me/name' link or numeric ID." required:""`
 		ImagePath string `arg:"" required:"" help:"Path to image."`
 		Caption   string `help:"Image caption."`
 	} `cmd:"" help:"Send image to specified user if --username is set and to default chat otherwise."`
@@ -43,13 +61,70 @@ type CLI struct {
 	} `cmd:"" help:"Send image to default chat."`
 	SendAdminMsg struct {
 		Message string `help:"Message text."`
-	} `cmd:"" help:"Send message to admin. '--admin' argument should be specified."`
+	} `cmd:"" help:"Send message to all admins. '--admin' argument or config admins should be specified."`
 	ForgetImg struct {
-		Url string `arg:"" required:"" help:"Image url."`
+		Url    string `arg:"" required:"" help:"Image url."`
+		Delete bool   `help:"Delete the messages the image was posted as."`
 	} `cmd:"" help:"Remove image url from internal db. Next time image's gonna be processed as new one."`
+	DeletePost struct {
+		Url string `arg:"" required:"" help:"Image url."`
+	} `cmd:"" help:"Delete the messages the image was posted as from all chats."`
+	EditCaption struct {
+		Url  string `arg:"" required:"" help:"Image url."`
+		Text string `arg:"" required:"" help:"New caption."`
+	} `cmd:"" help:"Replace the caption of the messages the image was posted as."`
+	ResumeChat struct {
+		Chat string `arg:"" required:"" help:"Chat: '@name', 't.me/name' link or numeric ID."`
+	} `cmd:"" help:"Resume posting to the chat paused after the Bot had been removed or lost its rights."`
+	History struct {
+		Url    string `arg:"" optional:"" help:"Show the image url only."`
+		Source string `help:"Source page url or name."`
+		From   string `help:"Show images first seen since the date (2006-01-02) or time (RFC 3339)."`
+		To     string `help:"Show images first seen till the date (inclusive) or time (exclusive)."`
+		Status string `help:"Image status: pending, posted, failed, forgotten, archived or duplicate." enum:",pending,posted,failed,forgotten,archived,duplicate" default:""`
+		Dest   string `help:"Destination chat the image was posted to: numeric ID or '@name' the Bot has resolved before."`
+		Format string `help:"Output format: table, json or csv." enum:"table,json,csv" default:"table"`
+	} `cmd:"" help:"List processed images in the order they were first seen."`
+	Db struct {
+		Export struct {
+			Out string `help:"Output file, the export is written to stdout if it isn't set." type:"path"`
+		} `cmd:"" help:"Export the database as JSON Lines with the schema version header."`
+		Import struct {
+			In   string `arg:"" required:"" help:"File written by 'db export'." type:"path"`
+			Mode string `help:"Import mode: merge keeps the keys missing in the file, replace drops the database first." enum:"merge,replace" default:"merge"`
+		} `cmd:"" help:"Import the database from the file written by 'db export'."`
+		Backup struct {
+			Out   string `help:"Backup file." type:"path" required:""`
+			Since uint64 `help:"Back up the keys changed after the version printed by the previous backup. Default is 0 which means full backup."`
+		} `cmd:"" help:"Write the binary backup of the database and print the version for the next incremental backup."`
+		Restore struct {
+			Files []string `arg:"" required:"" help:"The full backup followed by the incremental ones in the order they were taken." type:"path"`
+		} `cmd:"" help:"Replace the database with the backups."`
+		Prune struct {
+			DryRun bool `help:"Report the number of the records to remove without removing them."`
+		} `cmd:"" help:"Remove the records older than the retention of their sources set in the config."`
+	} `cmd:"" help:"Database maintenance."`
 	Report struct {
-	} `cmd:"" help:"Send daily report to the administrator."`
-	command string `-`
+	} `cmd:"" help:"Send daily report to the administrators."`
+	Daemon struct {
+		Interval       time.Duration `help:"Interval between fetches." default:"10m"`
+		Limit          int           `help:"Stop after processing of '--limit' number of items on every fetch."`
+		Urls           []string      `arg:"" optional:"" help:"Urls to fetch data from."`
+		BackupDir      string        `help:"Directory to write periodic full backups of the database to." type:"path"`
+		BackupInterval time.Duration `help:"Interval between backups." default:"24h"`
+		BackupKeep     int           `help:"Number of the latest backups to keep, 0 keeps all." default:"7"`
+		GcInterval     time.Duration `help:"Interval between database value log garbage collections, 0 disables them." default:"1h"`
+	} `cmd:"" help:"Fetch images periodically and handle the Bot updates (reactions etc.)."`
+	command string `kong:"-"`
+}
+
+// offline checks if the command works with the database only, so the Bot initialization is not needed.
+func (c *CLI) offline() bool {
+	switch c.command {
+	case CmdHistory, CmdDbExport, CmdDbImport, CmdDbBackup, CmdDbRestore, CmdDbPrune:
+		return true
+	}
+	return false
 }
 
 func cliParse() *CLI {
@@ -83,6 +158,26 @@ func cliParse() *CLI {
 		cli.command = CmdForgetImg
 	case strings.HasPrefix(k.Command(), CmdReport):
 		cli.command = CmdReport
+	case strings.HasPrefix(k.Command(), CmdDeletePost):
+		cli.command = CmdDeletePost
+	case strings.HasPrefix(k.Command(), CmdEditCaption):
+		cli.command = CmdEditCaption
+	case strings.HasPrefix(k.Command(), CmdResumeChat):
+		cli.command = CmdResumeChat
+	case strings.HasPrefix(k.Command(), CmdHistory):
+		cli.command = CmdHistory
+	case strings.HasPrefix(k.Command(), CmdDbExport):
+		cli.command = CmdDbExport
+	case strings.HasPrefix(k.Command(), CmdDbImport):
+		cli.command = CmdDbImport
+	case strings.HasPrefix(k.Command(), CmdDbBackup):
+		cli.command = CmdDbBackup
+	case strings.HasPrefix(k.Command(), CmdDbRestore):
+		cli.command = CmdDbRestore
+	case strings.HasPrefix(k.Command(), CmdDbPrune):
+		cli.command = CmdDbPrune
+	case strings.HasPrefix(k.Command(), CmdDaemon):
+		cli.command = CmdDaemon
 	default:
 		cli.command = "not specified"
 	}
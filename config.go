@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type (
+	// Config is loaded from the file specified by '--config' option.
+	Config struct {
+		Sources []*Source `json:"sources"`
+		Admins  []*Admin  `json:"admins,omitempty"`
+		// Suggestions are disabled if not set
+		Suggestions *Suggestions `json:"suggestions,omitempty"`
+	}
+
+	// Suggestions are the images users send to the Bot for the admins to review.
+	Suggestions struct {
+		// DailyQuota is the number of images a user may suggest per day
+		DailyQuota int `json:"daily_quota"`
+		// Credit adds the username of the submitter to the caption of the approved image
+		Credit bool `json:"credit,omitempty"`
+	}
+
+	// Source is a page images are fetched from and the list of chats they are posted to.
+	// Users subscribe to the source by its Name. Options are used by the destinations having no own options.
+	// Retention is how long the image urls are remembered, e.g. '30d' or '720h', it must be longer than
+	// the images stay on the page, otherwise they are posted again. HashRetention is the retention of the content
+	// hashes detecting the same image under another url, Retention is used if it isn't set.
+	// The records are kept forever if the retention isn't set.
+	Source struct {
+		Name          string         `json:"name,omitempty"`
+		Url           string         `json:"url"`
+		Options       *PostOptions   `json:"options,omitempty"`
+		Destinations  []*Destination `json:"destinations"`
+		Retention     string         `json:"retention,omitempty"`
+		HashRetention string         `json:"hash_retention,omitempty"`
+		retention     *Retention
+	}
+
+	// Destination is a chat the images are posted to.
+	// Caption is a text/template executed with CaptionData.
+	// MaxRpm and MaxRps override default rate limits of the chat.
+	Destination struct {
+		Chat    string       `json:"chat"`
+		Caption string       `json:"caption,omitempty"`
+		MaxRpm  float64      `json:"max_rpm,omitempty"`
+		MaxRps  float64      `json:"max_rps,omitempty"`
+		Options *PostOptions `json:"options,omitempty"`
+		chatId  int64
+		caption *template.Template
+	}
+
+	// QuietHours is the daily time range in "15:04" format, To may be less than From.
+	QuietHours struct {
+		From     string `json:"from"`
+		To       string `json:"to"`
+		from, to int    // minutes since midnight
+	}
+
+	CaptionData struct {
+		Src    string
+		Source string
+		Chat   string
+	}
+)
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse config file '%s': %w", path, err)
+	}
+	err = c.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Config) validate() error {
+	for _, a := range c.Admins {
+		err := a.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if c.Suggestions != nil && c.Suggestions.DailyQuota <= 0 {
+		return errors.New("suggestions daily quota must be positive")
+	}
+	for _, s := range c.Sources {
+		if s.Url == "" {
+			return errors.New("source url is empty")
+		}
+		if len(s.Destinations) == 0 {
+			return fmt.Errorf("source '%s' has no destinations", s.Url)
+		}
+		s.retention = &Retention{}
+		var err error
+		s.retention.Urls, err = parseRetention(s.Retention)
+		if err != nil {
+			return fmt.Errorf("source '%s': %w", s.Url, err)
+		}
+		s.retention.Hashes, err = parseRetention(s.HashRetention)
+		if err != nil {
+			return fmt.Errorf("source '%s': %w", s.Url, err)
+		}
+		for _, d := range s.Destinations {
+			if d.Chat == "" {
+				return fmt.Errorf("source '%s' has destination with empty chat", s.Url)
+			}
+			if d.Options == nil {
+				d.Options = s.Options
+			}
+			if d.Options != nil && d.Options.QuietHours != nil {
+				err := d.Options.QuietHours.parse()
+				if err != nil {
+					return fmt.Errorf("invalid quiet hours of '%s': %w", d.Chat, err)
+				}
+			}
+			if d.Caption != "" {
+				t, err := template.New(d.Chat).Parse(d.Caption)
+				if err != nil {
+					return fmt.Errorf("invalid caption template of '%s': %w", d.Chat, err)
+				}
+				d.caption = t
+			}
+		}
+	}
+	return nil
+}
+
+// Source returns the config entry of the url or nil if the url isn't configured.
+func (c *Config) Source(url string) *Source {
+	if c == nil {
+		return nil
+	}
+	for _, s := range c.Sources {
+		if s.Url == url {
+			return s
+		}
+	}
+	return nil
+}
+
+// SourceByName finds the source by its name or url.
+func (c *Config) SourceByName(name string) *Source {
+	if c == nil {
+		return nil
+	}
+	for _, s := range c.Sources {
+		if strings.EqualFold(s.Name, name) || s.Url == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Destinations returns all the destinations of all sources.
+func (c *Config) Destinations() []*Destination {
+	if c == nil {
+		return nil
+	}
+	var res []*Destination
+	for _, s := range c.Sources {
+		res = append(res, s.Destinations...)
+	}
+	return res
+}
+
+func (d *Destination) FormatCaption(data *CaptionData) (string, error) {
+	if d.caption == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	err := d.caption.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (q *QuietHours) parse() error {
+	from, err := time.Parse("15:04", q.From)
+	if err != nil {
+		return err
+	}
+	to, err := time.Parse("15:04", q.To)
+	if err != nil {
+		return err
+	}
+	q.from = from.Hour()*60 + from.Minute()
+	q.to = to.Hour()*60 + to.Minute()
+	return nil
+}
+
+// Contains checks if the local time of t is within the quiet hours.
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	if q.from <= q.to {
+		return m >= q.from && m < q.to
+	}
+	// the range passes midnight
+	return m >= q.from || m < q.to
+}
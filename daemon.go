@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mymmrac/telego"
+	"github.com/phuslu/log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RunDaemon fetches the urls every interval, handles the Bot updates and maintains the database
+// until the process is interrupted.
+func (g *Gorobei) RunDaemon(urls []string, interval time.Duration, limit int, m *Maintenance) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %v", interval)
+	}
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		log.Info().Str("signal", s.String()).Msg("stopping daemon")
+		close(stop)
+	}()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- g.tg.Listen(stop, g.handleUpdate)
+	}()
+	go g.runMaintenance(m, stop)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, url := range urls {
+			err := g.Fetch(url, limit)
+			if err != nil {
+				log.Error().Err(err).Str("url", url).Msg("cannot fetch images")
+			}
+		}
+		select {
+		case <-stop:
+			return <-listenErr
+		case err := <-listenErr:
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *Gorobei) handleUpdate(u *telego.Update) {
+	// the handlers post images and read the chat ids the fetch loop may migrate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case u.CallbackQuery != nil:
+		g.handleCallback(u.CallbackQuery)
+	case u.Message != nil:
+		if !g.handleSubmission(u.Message) && !g.handleSuggestion(u.Message) {
+			g.handleCommand(u.Message)
+		}
+	}
+}
+
+func (g *Gorobei) handleCallback(q *telego.CallbackQuery) {
+	if q.Message == nil {
+		return
+	}
+	var up bool
+	switch {
+	case q.Data == CallbackVoteUp:
+		up = true
+	case q.Data == CallbackVoteDown:
+		up = false
+	case strings.HasPrefix(q.Data, CallbackPost):
+		g.handlePostCallback(q)
+		return
+	case strings.HasPrefix(q.Data, CallbackApprove), strings.HasPrefix(q.Data, CallbackReject):
+		g.handleReviewCallback(q)
+		return
+	default:
+		log.Error().Str("data", q.Data).Msg("unexpected callback data")
+		return
+	}
+	chatId, messageId := q.Message.Chat.ID, q.Message.MessageID
+	answer := "Thank you!"
+	t, err := g.d.StoreVote(chatId, messageId, q.From.ID, up)
+	switch {
+	case errors.Is(err, ErrAlreadyVoted):
+		answer = "You have voted already."
+	case err != nil:
+		log.Error().Err(err).Int64("chat_id", chatId).Int("message_id", messageId).Msg("cannot store vote")
+		answer = "Sorry, something went wrong."
+	default:
+		err = g.tg.SetReactions(chatId, messageId, t.Up, t.Down, q.Message.ReplyMarkup)
+		if err != nil {
+			log.Error().Err(err).Int64("chat_id", chatId).Int("message_id", messageId).Msg("cannot update reactions")
+		}
+	}
+	err = g.tg.AnswerCallback(q.ID, answer)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot answer callback query")
+	}
+}
@@ -4,16 +4,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
-	"github.com/phuslu/log"
 	"gorobei/utils"
+	"sort"
 	"strings"
 	"time"
 )
 
 type (
+	// Db keeps the state of the Bot in the key-value engine.
 	Db struct {
-		b *badger.DB
+		b kvEngine
+		// retention of the source images by the source url
+		retention map[string]*Retention
 	}
 
 	DailyReport struct {
@@ -25,56 +27,93 @@ type (
 		SentAt time.Time
 	}
 
+	// VoteTally is the number of reactions to the posted image
+	VoteTally struct {
+		ChatId    int64
+		MessageId int
+		Url       string
+		Up        int
+		Down      int
+	}
+
+	// Subscription of the user to receive images of the source in DMs,
+	// Digest subscribers receive the images once a day.
+	Subscription struct {
+		UserId int64
+		Source string
+		Digest bool
+	}
+
+	// Suggestion is the image the user sent to the Bot, it waits for the admins' review
+	Suggestion struct {
+		UserId    int64
+		Username  string
+		MessageId int
+		Submission
+		CreatedAt time.Time
+	}
+
+	// Submission is the image the admin sent to the Bot to be posted: a photo, an image document or an image url.
+	Submission struct {
+		Url          string
+		FileId       string
+		FileUniqueId string
+		Document     bool
+		Caption      string
+	}
+
+	// User is the one who has sent a message to the Bot
+	User struct {
+		Id        int64
+		Username  string
+		FirstName string `json:",omitempty"`
+		LastName  string `json:",omitempty"`
+		LastSeen  time.Time
+	}
+
 	UsersStore interface {
+		StoreUser(u *User) error
 		StoreUserId(user string, id int64) error
 		ReadUserId(user string) (int64, error)
+		// ReadChatId returns the cached ID of the '@name' chat
+		ReadChatId(name string) (int64, error)
 	}
 )
 
 var (
-	ErrNotFound = errors.New("key not found")
+	ErrNotFound     = errors.New("key not found")
+	ErrAlreadyVoted = errors.New("user has voted already")
 	_ UsersStore = (*Db)(nil)
 )
 
-var _ badger.Logger = (*LogAdapter)(nil)
-
-func OpenDb(path string) (*Db, error) {
-	log.Info().Str("path", path).Msg("opening database")
-	opts := badger.DefaultOptions(path).
-		WithValueLogFileSize(10 * 1024 * 1024).
-		WithLogger(&LogAdapter{&log.DefaultLogger})
-
-	d, err := badger.Open(opts)
-
-	if err != nil {
-		return nil, err
-	}
-
-	err = d.RunValueLogGC(0.5)
-	if err != nil && !errors.Is(err, badger.ErrNoRewrite) {
-		return nil, err
-	}
-
-	return &Db{b: d}, nil
+func (t *VoteTally) Score() int {
+	return t.Up - t.Down
 }
 
 func (d *Db) Close() error {
 	return d.b.Close()
 }
 
-func (d *Db) StoreUrlProcessed(key string) (byte, error) {
+func (d *Db) constructUrlKey(url string) []byte {
+	return []byte(nsUrl + url)
+}
+
+func (d *Db) constructUrlSentKey(url string, chatId int64) []byte {
+	return []byte(fmt.Sprintf(nsSent+"%v_%s", chatId, url))
+}
+
+// ReadUrlSent returns the state of the url posted to the chat. Images are tracked per destination, so a failure
+// in one chat doesn't cause duplicate posts to the others.
+func (d *Db) ReadUrlSent(url string, chatId int64) (byte, error) {
 	var v []byte
 
-	err := d.b.View(func(txn *badger.Txn) error {
-		val, err := txn.Get([]byte(key))
-		if err != nil {
-			return err
-		}
-		v, err = val.ValueCopy(nil)
+	err := d.b.View(func(txn kvTxn) error {
+		var err error
+		v, err = txn.Get(d.constructUrlSentKey(url, chatId))
 		return err
 	})
 
-	if errors.Is(err, badger.ErrKeyNotFound) {
+	if errors.Is(err, errKeyNotFound) {
 		return 0, ErrNotFound
 	}
 	if err != nil {
@@ -86,25 +125,157 @@ func (d *Db) StoreUrlProcessed(key string) (byte, error) {
 	return v[0], nil
 }
 
-func (d *Db) ReadUrlProcessed(key string, value byte) error {
+// StoreUrlSent stores the state of the url posted to the chat, it expires along with the content hash
+// of the source images, so duplicates are detected as long as the hash is kept.
+func (d *Db) StoreUrlSent(url string, source string, chatId int64, value byte) error {
+	expiresAt := d.expiresAt(d.retentionOf(source).sent())
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.SetEntry(d.constructUrlSentKey(url, chatId), []byte{value}, expiresAt)
+	})
+}
 
-	err := d.b.Update(func(txn *badger.Txn) error {
-		err := txn.Set([]byte(key), []byte{value})
+func (d *Db) constructPostPrefix(url string) []byte {
+	return []byte(nsPost + url + "\x00")
+}
+
+// StorePost links the image url to the message it was posted as.
+func (d *Db) StorePost(url string, m *SentMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
 		return err
+	}
+	key := append(d.constructPostPrefix(url), []byte(fmt.Sprintf("%v", m.ChatId))...)
+	return d.b.Update(func(txn kvTxn) error {
+		err := txn.Set(key, data)
+		if err != nil {
+			return err
+		}
+		// the reverse link is used to find the image by the message
+		return txn.Set(d.constructMessageKey(m.ChatId, m.MessageId), []byte(url))
 	})
+}
 
-	return err
+func (d *Db) constructMessageKey(chatId int64, messageId int) []byte {
+	return []byte(fmt.Sprintf(nsMessage+"%v_%v", chatId, messageId))
+}
+
+// ReadMessageUrl returns the image url the message was posted for.
+func (d *Db) ReadMessageUrl(chatId int64, messageId int) (string, error) {
+	var v []byte
+	err := d.b.View(func(txn kvTxn) error {
+		var err error
+		v, err = txn.Get(d.constructMessageKey(chatId, messageId))
+		return err
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return "", ErrNotFound
+	}
+	return string(v), err
+}
+
+// ReadPosts returns the messages of the image url in all chats.
+func (d *Db) ReadPosts(url string) ([]*SentMessage, error) {
+	var res []*SentMessage
+	prefix := d.constructPostPrefix(url)
+	err := d.b.View(func(txn kvTxn) error {
+		return txn.Scan(prefix, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			var m SentMessage
+			err := json.Unmarshal(value, &m)
+			if err != nil {
+				return err
+			}
+			res = append(res, &m)
+			return nil
+		})
+	})
+	return res, err
+}
+
+// DeletePost removes the message of the image url in the chat along with its reverse link and its entry
+// in the image record.
+func (d *Db) DeletePost(url string, chatId int64) error {
+	key := append(d.constructPostPrefix(url), []byte(fmt.Sprintf("%v", chatId))...)
+	return d.b.Update(func(txn kvTxn) error {
+		v, err := txn.Get(key)
+		if errors.Is(err, errKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var m SentMessage
+		err = json.Unmarshal(v, &m)
+		if err != nil {
+			return err
+		}
+		err = txn.Delete(d.constructMessageKey(m.ChatId, m.MessageId))
+		if err != nil {
+			return err
+		}
+		err = txn.Delete(key)
+		if err != nil {
+			return err
+		}
+		old, err := readImage(txn, d.constructUrlKey(url), url)
+		if errors.Is(err, errKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r := *old
+		r.Posts = nil
+		for _, p := range old.Posts {
+			if p.ChatId != m.ChatId || p.MessageId != m.MessageId {
+				r.Posts = append(r.Posts, p)
+			}
+		}
+		return d.setImage(txn, old, &r)
+	})
+}
+
+func (d *Db) constructImageFileKey(url string) []byte {
+	return []byte(nsFile + url)
+}
+
+// StoreImageFile stores the Telegram file_id of the uploaded image, so it can be posted again without uploading.
+func (d *Db) StoreImageFile(url string, m *SentMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Set(d.constructImageFileKey(url), data)
+	})
+}
+
+func (d *Db) ReadImageFile(url string) (*SentMessage, error) {
+	var m SentMessage
+	err := d.b.View(func(txn kvTxn) error {
+		v, err := txn.Get(d.constructImageFileKey(url))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(v, &m)
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
 }
 
 func (d *Db) constructUserKey(user string) []byte {
-	return []byte("username_" + strings.ToLower(user))
+	return []byte(nsUsername + strings.ToLower(user))
 }
 
 func (d *Db) StoreUserId(user string, id int64) error {
 	if user == "" {
 		return errors.New("empty user name")
 	}
-	err := d.b.Update(func(txn *badger.Txn) error {
+	err := d.b.Update(func(txn kvTxn) error {
 		err := txn.Set(d.constructUserKey(user), utils.Int64ToByteArr(id))
 		return err
 	})
@@ -112,19 +283,85 @@ func (d *Db) StoreUserId(user string, id int64) error {
 	return err
 }
 
-func (d *Db) ReadUserId(user string) (int64, error) {
-	var v []byte
+func (d *Db) constructUserIdKey(id int64) []byte {
+	return []byte(fmt.Sprintf(nsUser+"%v", id))
+}
 
-	err := d.b.View(func(txn *badger.Txn) error {
-		val, err := txn.Get(d.constructUserKey(user))
+// StoreUser updates the user attributes. The username index follows renames: the old username is removed
+// unless another user has taken it already.
+func (d *Db) StoreUser(u *User) error {
+	if u.Username == "" {
+		return errors.New("empty user name")
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return d.b.Update(func(txn kvTxn) error {
+		old, err := readUser(txn, d.constructUserIdKey(u.Id))
+		if err != nil && !errors.Is(err, errKeyNotFound) {
+			return err
+		}
+		if old != nil && !strings.EqualFold(old.Username, u.Username) {
+			key := d.constructUserKey(old.Username)
+			v, err := txn.Get(key)
+			switch {
+			case err == nil:
+				if id, _ := utils.ByteArrToInt64(v); id == u.Id {
+					err = txn.Delete(key)
+					if err != nil {
+						return err
+					}
+				}
+			case !errors.Is(err, errKeyNotFound):
+				return err
+			}
+		}
+		err = txn.Set(d.constructUserKey(u.Username), utils.Int64ToByteArr(u.Id))
 		if err != nil {
 			return err
 		}
-		v, err = val.ValueCopy(nil)
+		return txn.Set(d.constructUserIdKey(u.Id), data)
+	})
+}
+
+func readUser(txn kvTxn, key []byte) (*User, error) {
+	v, err := txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var u User
+	err = json.Unmarshal(v, &u)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ReadUser returns the user by chat ID.
+func (d *Db) ReadUser(id int64) (*User, error) {
+	var u *User
+	err := d.b.View(func(txn kvTxn) error {
+		var err error
+		u, err = readUser(txn, d.constructUserIdKey(id))
 		return err
 	})
+	if errors.Is(err, errKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	return u, err
+}
 
-	if errors.Is(err, badger.ErrKeyNotFound) {
+func (d *Db) ReadUserId(user string) (int64, error) {
+	var v []byte
+
+	err := d.b.View(func(txn kvTxn) error {
+		var err error
+		v, err = txn.Get(d.constructUserKey(user))
+		return err
+	})
+
+	if errors.Is(err, errKeyNotFound) {
 		return 0, ErrNotFound
 	}
 	if err != nil {
@@ -137,11 +374,513 @@ func (d *Db) ReadUserId(user string) (int64, error) {
 	return id, nil
 }
 
-var dbKeyDailyReport = []byte("daily_report")
+func (d *Db) constructChatIdKey(name string) []byte {
+	return []byte(nsChatId + strings.ToLower(name))
+}
+
+// StoreChatId caches the ID of the chat resolved by its '@name'.
+func (d *Db) StoreChatId(name string, id int64) error {
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Set(d.constructChatIdKey(name), utils.Int64ToByteArr(id))
+	})
+}
+
+func (d *Db) ReadChatId(name string) (int64, error) {
+	var v []byte
+	err := d.b.View(func(txn kvTxn) error {
+		var err error
+		v, err = txn.Get(d.constructChatIdKey(name))
+		return err
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return utils.ByteArrToInt64(v)
+}
+
+func (d *Db) constructVoteKey(chatId int64, messageId int, userId int64) []byte {
+	return []byte(fmt.Sprintf(nsVote+"%v_%v_%v", chatId, messageId, userId))
+}
+
+func (d *Db) constructTallyKey(chatId int64, messageId int) []byte {
+	return []byte(fmt.Sprintf(nsTally+"%v_%v", chatId, messageId))
+}
+
+// ReadTally returns the votes for the message or ErrNotFound if nobody has voted.
+func (d *Db) ReadTally(chatId int64, messageId int) (*VoteTally, error) {
+	var t VoteTally
+	err := d.b.View(func(txn kvTxn) error {
+		v, err := txn.Get(d.constructTallyKey(chatId, messageId))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(v, &t)
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// StoreVote registers the vote of the user and returns updated tally of the message.
+// Every user can vote for the message only once, ErrAlreadyVoted is returned otherwise.
+func (d *Db) StoreVote(chatId int64, messageId int, userId int64, up bool) (*VoteTally, error) {
+	var t VoteTally
+	err := d.b.Update(func(txn kvTxn) error {
+		voteKey := d.constructVoteKey(chatId, messageId, userId)
+		_, err := txn.Get(voteKey)
+		if err == nil {
+			return ErrAlreadyVoted
+		}
+		if !errors.Is(err, errKeyNotFound) {
+			return err
+		}
+		tallyKey := d.constructTallyKey(chatId, messageId)
+		v, err := txn.Get(tallyKey)
+		switch {
+		case err == nil:
+			err = json.Unmarshal(v, &t)
+			if err != nil {
+				return err
+			}
+		case errors.Is(err, errKeyNotFound):
+			t = VoteTally{ChatId: chatId, MessageId: messageId}
+			url, err := txn.Get(d.constructMessageKey(chatId, messageId))
+			if err == nil {
+				t.Url = string(url)
+			}
+			if err != nil && !errors.Is(err, errKeyNotFound) {
+				return err
+			}
+		default:
+			return err
+		}
+		var vote byte
+		if up {
+			t.Up += 1
+			vote = 1
+		} else {
+			t.Down += 1
+		}
+		data, err := json.Marshal(&t)
+		if err != nil {
+			return err
+		}
+		err = txn.Set(tallyKey, data)
+		if err != nil {
+			return err
+		}
+		return txn.Set(voteKey, []byte{vote})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// TopVoted returns at most n messages having the best score (likes minus dislikes).
+func (d *Db) TopVoted(n int) ([]*VoteTally, error) {
+	var res []*VoteTally
+	prefix := []byte(nsTally)
+	err := d.b.View(func(txn kvTxn) error {
+		return txn.Scan(prefix, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			var t VoteTally
+			err := json.Unmarshal(value, &t)
+			if err != nil {
+				return err
+			}
+			res = append(res, &t)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Score() > res[j].Score()
+	})
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res, nil
+}
+
+func (d *Db) constructSubscriptionKey(source string, userId int64) []byte {
+	return []byte(fmt.Sprintf(nsSub+"%s\x00%v", source, userId))
+}
+
+func (d *Db) StoreSubscription(s *Subscription) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Set(d.constructSubscriptionKey(s.Source, s.UserId), data)
+	})
+}
+
+func (d *Db) DeleteSubscription(source string, userId int64) error {
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Delete(d.constructSubscriptionKey(source, userId))
+	})
+}
+
+// ReadSubscriptions returns subscriptions to the source, all the subscriptions are returned if source is empty.
+func (d *Db) ReadSubscriptions(source string) ([]*Subscription, error) {
+	var res []*Subscription
+	prefix := []byte(nsSub)
+	if source != "" {
+		prefix = []byte(nsSub + source + "\x00")
+	}
+	err := d.b.View(func(txn kvTxn) error {
+		return txn.Scan(prefix, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			var s Subscription
+			err := json.Unmarshal(value, &s)
+			if err != nil {
+				return err
+			}
+			res = append(res, &s)
+			return nil
+		})
+	})
+	return res, err
+}
+
+func (d *Db) constructDigestPrefix(userId int64) []byte {
+	return []byte(fmt.Sprintf(nsDigest+"%v\x00", userId))
+}
+
+// QueueDigest adds the uploaded image to the user's digest.
+func (d *Db) QueueDigest(userId int64, url string, fileId string) error {
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Set(append(d.constructDigestPrefix(userId), []byte(url)...), []byte(fileId))
+	})
+}
+
+// ReadDigest returns file IDs of the images queued for the user.
+func (d *Db) ReadDigest(userId int64) ([]string, error) {
+	var res []string
+	prefix := d.constructDigestPrefix(userId)
+	err := d.b.View(func(txn kvTxn) error {
+		return txn.Scan(prefix, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			res = append(res, string(value))
+			return nil
+		})
+	})
+	return res, err
+}
+
+// DeleteDigest removes the file IDs from the user's digest.
+func (d *Db) DeleteDigest(userId int64, fileIds []string) error {
+	del := make(map[string]bool, len(fileIds))
+	for _, id := range fileIds {
+		del[id] = true
+	}
+	return d.b.Update(func(txn kvTxn) error {
+		var keys [][]byte
+		err := txn.Scan(d.constructDigestPrefix(userId), nil, func(key []byte, value []byte, expiresAt uint64) error {
+			if del[string(value)] {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			err = txn.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClearDigest removes all the images queued for the user.
+func (d *Db) ClearDigest(userId int64) error {
+	return d.b.Update(func(txn kvTxn) error {
+		var keys [][]byte
+		err := txn.Scan(d.constructDigestPrefix(userId), nil, func(key []byte, value []byte, expiresAt uint64) error {
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			err = txn.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *Db) constructImageHashKey(hash string) []byte {
+	return []byte(nsHash + hash)
+}
+
+// StoreImageHash remembers the url of the first image having the content hash.
+// The url stored earlier is kept and returned.
+func (d *Db) StoreImageHash(hash string, url string, source string) (string, error) {
+	expiresAt := d.expiresAt(d.retentionOf(source).hashes())
+	var first string
+	err := d.b.Update(func(txn kvTxn) error {
+		v, err := txn.Get(d.constructImageHashKey(hash))
+		if err == nil {
+			first = string(v)
+			return nil
+		}
+		if !errors.Is(err, errKeyNotFound) {
+			return err
+		}
+		first = url
+		return txn.SetEntry(d.constructImageHashKey(hash), []byte(url), expiresAt)
+	})
+	return first, err
+}
+
+func (d *Db) constructSubmissionKey(userId int64, messageId int) []byte {
+	return []byte(fmt.Sprintf(nsSubmission+"%v_%v", userId, messageId))
+}
+
+func (d *Db) StoreSubmission(userId int64, messageId int, s *Submission) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Set(d.constructSubmissionKey(userId, messageId), data)
+	})
+}
+
+func (d *Db) ReadSubmission(userId int64, messageId int) (*Submission, error) {
+	var s Submission
+	err := d.b.View(func(txn kvTxn) error {
+		v, err := txn.Get(d.constructSubmissionKey(userId, messageId))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(v, &s)
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (d *Db) constructSuggestionKey(userId int64, messageId int) []byte {
+	return []byte(fmt.Sprintf(nsSuggestion+"%v_%v", userId, messageId))
+}
+
+// StoreSuggestion adds the suggestion to the review queue.
+func (d *Db) StoreSuggestion(s *Suggestion) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Set(d.constructSuggestionKey(s.UserId, s.MessageId), data)
+	})
+}
+
+// SuggestWithQuota counts the suggestion of the user for the day and adds it to the review queue in one
+// transaction, false is returned and nothing is stored if the limit is reached.
+func (d *Db) SuggestWithQuota(s *Suggestion, day string, limit int) (bool, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return false, err
+	}
+	var ok bool
+	err = d.b.Update(func(txn kvTxn) error {
+		var err error
+		ok, err = d.takeQuota(txn, s.UserId, day, limit)
+		if err != nil || !ok {
+			return err
+		}
+		return txn.Set(d.constructSuggestionKey(s.UserId, s.MessageId), data)
+	})
+	return ok, err
+}
+
+// TakeSuggestion removes the suggestion from the review queue and returns it.
+// ErrNotFound is returned if it has been reviewed already.
+func (d *Db) TakeSuggestion(userId int64, messageId int) (*Suggestion, error) {
+	var s Suggestion
+	err := d.b.Update(func(txn kvTxn) error {
+		key := d.constructSuggestionKey(userId, messageId)
+		v, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(v, &s)
+		if err != nil {
+			return err
+		}
+		return txn.Delete(key)
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ReadSuggestions returns the review queue.
+func (d *Db) ReadSuggestions() ([]*Suggestion, error) {
+	var res []*Suggestion
+	prefix := []byte(nsSuggestion)
+	err := d.b.View(func(txn kvTxn) error {
+		return txn.Scan(prefix, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			var s Suggestion
+			err := json.Unmarshal(value, &s)
+			if err != nil {
+				return err
+			}
+			res = append(res, &s)
+			return nil
+		})
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+	return res, err
+}
+
+// TakeQuota counts the suggestion of the user for the day, false is returned if the limit is reached.
+func (d *Db) TakeQuota(userId int64, day string, limit int) (bool, error) {
+	var ok bool
+	err := d.b.Update(func(txn kvTxn) error {
+		var err error
+		ok, err = d.takeQuota(txn, userId, day, limit)
+		return err
+	})
+	return ok, err
+}
+
+func (d *Db) takeQuota(txn kvTxn, userId int64, day string, limit int) (bool, error) {
+	key := []byte(fmt.Sprintf(nsQuota+"%v_%s", userId, day))
+	var n int64
+	v, err := txn.Get(key)
+	switch {
+	case err == nil:
+		n, err = utils.ByteArrToInt64(v)
+		if err != nil {
+			return false, err
+		}
+	case !errors.Is(err, errKeyNotFound):
+		return false, err
+	}
+	if n >= int64(limit) {
+		return false, nil
+	}
+	// the counters of the past days are not needed
+	return true, txn.SetEntry(key, utils.Int64ToByteArr(n+1), d.expiresAt(48*time.Hour))
+}
+
+func (d *Db) constructMigrationKey(chatId int64) []byte {
+	return []byte(fmt.Sprintf(nsMigrated+"%v", chatId))
+}
+
+// MigrateChat records that the group has been upgraded to a supergroup and moves the state of the images
+// sent to the group to the new chat ID.
+func (d *Db) MigrateChat(from int64, to int64) error {
+	oldPrefix := []byte(fmt.Sprintf(nsSent+"%v_", from))
+	newPrefix := []byte(fmt.Sprintf(nsSent+"%v_", to))
+	return d.b.Update(func(txn kvTxn) error {
+		var (
+			keys, values [][]byte
+			expires      []uint64
+		)
+		err := txn.Scan(oldPrefix, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			keys = append(keys, key)
+			values = append(values, value)
+			expires = append(expires, expiresAt)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			err := txn.SetEntry(append(append([]byte{}, newPrefix...), k[len(oldPrefix):]...), values[i], expires[i])
+			if err != nil {
+				return err
+			}
+			err = txn.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return txn.Set(d.constructMigrationKey(from), utils.Int64ToByteArr(to))
+	})
+}
+
+// ReadChatMigration returns the ID of the supergroup the chat has been migrated to.
+func (d *Db) ReadChatMigration(chatId int64) (int64, error) {
+	var v []byte
+	err := d.b.View(func(txn kvTxn) error {
+		var err error
+		v, err = txn.Get(d.constructMigrationKey(chatId))
+		return err
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return utils.ByteArrToInt64(v)
+}
+
+func (d *Db) constructPauseKey(chatId int64) []byte {
+	return []byte(fmt.Sprintf(nsPaused+"%v", chatId))
+}
+
+// StoreChatPause stops posting to the chat until it is resumed.
+func (d *Db) StoreChatPause(chatId int64, reason string) error {
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Set(d.constructPauseKey(chatId), []byte(reason))
+	})
+}
+
+// ReadChatPause returns the reason the chat is paused for or ErrNotFound if it isn't paused.
+func (d *Db) ReadChatPause(chatId int64) (string, error) {
+	var v []byte
+	err := d.b.View(func(txn kvTxn) error {
+		var err error
+		v, err = txn.Get(d.constructPauseKey(chatId))
+		return err
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return "", ErrNotFound
+	}
+	return string(v), err
+}
+
+func (d *Db) DeleteChatPause(chatId int64) error {
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Delete(d.constructPauseKey(chatId))
+	})
+}
+
+var dbKeyDailyReport = []byte(nsMeta + "daily_report")
 
 func (d *Db) StoreDailyReport(r *DailyReport) error {
 	var err error
-	err = d.b.Update(func(txn *badger.Txn) error {
+	err = d.b.Update(func(txn kvTxn) error {
 		data, err := json.Marshal(r)
 		if err != nil {
 			return err
@@ -156,19 +895,16 @@ func (d *Db) ReadDailyReport() (*DailyReport, error) {
 	var er2 error
 	var r DailyReport
 
-	er2 = d.b.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(dbKeyDailyReport)
+	er2 = d.b.View(func(txn kvTxn) error {
+		v, err := txn.Get(dbKeyDailyReport)
 		if err != nil {
-			if errors.Is(err, badger.ErrKeyNotFound) {
+			if errors.Is(err, errKeyNotFound) {
 				return ErrNotFound
 			}
 			return err
 		}
 
-		err = item.Value(func(val []byte) error {
-			err := json.Unmarshal(val, &r)
-			return err
-		})
+		err = json.Unmarshal(v, &r)
 		return err
 
 	})
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/phuslu/log"
+	"gorobei/utils"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type (
+	// exportHeader is the first line of the export file
+	exportHeader struct {
+		Format        string    `json:"format"`
+		SchemaVersion int       `json:"schema_version"`
+		CreatedAt     time.Time `json:"created_at"`
+	}
+
+	// exportRecord is the key of the database, the value is written in its readable form
+	exportRecord struct {
+		Key       string          `json:"key"`
+		Value     json.RawMessage `json:"value"`
+		ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+	}
+
+	// importEntry is the validated record ready to be written
+	importEntry struct {
+		key       []byte
+		value     []byte
+		expiresAt uint64
+	}
+
+	// valueCodec converts the stored value to its readable form and back. decode validates the value.
+	valueCodec struct {
+		encode func(v []byte) (json.RawMessage, error)
+		decode func(v json.RawMessage) ([]byte, error)
+	}
+)
+
+const (
+	exportFormat = "gorobei-db"
+
+	// ImportMerge keeps the keys missing in the file, the keys present in both are overwritten
+	ImportMerge = "merge"
+	// ImportReplace drops the database before the import
+	ImportReplace = "replace"
+
+	// maxExportLine is the longest line accepted on import
+	maxExportLine = 16 * 1024 * 1024
+)
+
+// exportCodecs are the namespaces exported, the namespaces derived from others (seen_) are rebuilt on import.
+var exportCodecs = map[string]valueCodec{
+	nsMeta:       jsonCodec(func() interface{} { return &DailyReport{} }),
+	nsUrl:        imageCodec(),
+	nsSent:       byteCodec(),
+	nsPost:       jsonCodec(func() interface{} { return &SentMessage{} }),
+	nsMessage:    stringCodec(),
+	nsFile:       jsonCodec(func() interface{} { return &SentMessage{} }),
+	nsUsername:   int64Codec(),
+	nsUser:       jsonCodec(func() interface{} { return &User{} }),
+	nsChatId:     int64Codec(),
+	nsVote:       byteCodec(),
+	nsTally:      jsonCodec(func() interface{} { return &VoteTally{} }),
+	nsSub:        jsonCodec(func() interface{} { return &Subscription{} }),
+	nsDigest:     stringCodec(),
+	nsHash:       stringCodec(),
+	nsSubmission: jsonCodec(func() interface{} { return &Submission{} }),
+	nsSuggestion: jsonCodec(func() interface{} { return &Suggestion{} }),
+	nsQuota:      int64Codec(),
+	nsMigrated:   int64Codec(),
+	nsPaused:     stringCodec(),
+}
+
+func jsonCodec(newValue func() interface{}) valueCodec {
+	return valueCodec{
+		encode: func(v []byte) (json.RawMessage, error) {
+			if !json.Valid(v) {
+				return nil, errors.New("invalid json")
+			}
+			return v, nil
+		},
+		decode: func(v json.RawMessage) ([]byte, error) {
+			dec := json.NewDecoder(strings.NewReader(string(v)))
+			dec.DisallowUnknownFields()
+			err := dec.Decode(newValue())
+			if err != nil {
+				return nil, err
+			}
+			return v, nil
+		},
+	}
+}
+
+func stringCodec() valueCodec {
+	return valueCodec{
+		encode: func(v []byte) (json.RawMessage, error) {
+			if !utf8.Valid(v) {
+				return nil, errors.New("invalid utf-8 string")
+			}
+			return json.Marshal(string(v))
+		},
+		decode: func(v json.RawMessage) ([]byte, error) {
+			var s string
+			err := json.Unmarshal(v, &s)
+			return []byte(s), err
+		},
+	}
+}
+
+func int64Codec() valueCodec {
+	return valueCodec{
+		encode: func(v []byte) (json.RawMessage, error) {
+			n, err := utils.ByteArrToInt64(v)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(n)
+		},
+		decode: func(v json.RawMessage) ([]byte, error) {
+			var n int64
+			err := json.Unmarshal(v, &n)
+			return utils.Int64ToByteArr(n), err
+		},
+	}
+}
+
+func byteCodec() valueCodec {
+	return valueCodec{
+		encode: func(v []byte) (json.RawMessage, error) {
+			if len(v) != 1 {
+				return nil, fmt.Errorf("expected 1 byte, got %v", len(v))
+			}
+			return json.Marshal(v[0])
+		},
+		decode: func(v json.RawMessage) ([]byte, error) {
+			var b byte
+			err := json.Unmarshal(v, &b)
+			return []byte{b}, err
+		},
+	}
+}
+
+// imageCodec exports the image record without the version byte, the version is implied by the schema version.
+func imageCodec() valueCodec {
+	return valueCodec{
+		encode: func(v []byte) (json.RawMessage, error) {
+			r, err := decodeImageRecord("", v)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(r)
+		},
+		decode: func(v json.RawMessage) ([]byte, error) {
+			var r ImageRecord
+			dec := json.NewDecoder(strings.NewReader(string(v)))
+			dec.DisallowUnknownFields()
+			err := dec.Decode(&r)
+			if err != nil {
+				return nil, err
+			}
+			switch r.Status {
+			case ImagePending, ImagePosted, ImageFailed, ImageForgotten, ImageArchived, ImageDuplicate:
+			default:
+				return nil, fmt.Errorf("unknown image status '%s'", r.Status)
+			}
+			return encodeImageRecord(&r)
+		},
+	}
+}
+
+// keyNamespace returns the namespace of the key or an empty string for unknown ones.
+func keyNamespace(key string) string {
+	for ns := range exportCodecs {
+		if strings.HasPrefix(key, ns) {
+			return ns
+		}
+	}
+	return ""
+}
+
+// Export writes the header and all the keys of the database as JSON Lines, it returns the number of the keys written.
+func (d *Db) Export(w io.Writer) (int, error) {
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	// urls are more readable without & escaped
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(&exportHeader{Format: exportFormat, SchemaVersion: version, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = d.b.View(func(txn kvTxn) error {
+		return txn.Scan(nil, nil, func(k []byte, v []byte, expiresAt uint64) error {
+			key := string(k)
+			if key == string(dbKeySchemaVersion) || strings.HasPrefix(key, nsSeen) {
+				return nil
+			}
+			if !utf8.ValidString(key) {
+				return fmt.Errorf("key %q is not valid utf-8", key)
+			}
+			codec, ok := exportCodecs[keyNamespace(key)]
+			if !ok {
+				return fmt.Errorf("key '%s' has unknown namespace", key)
+			}
+			rec := &exportRecord{Key: key}
+			var err error
+			rec.Value, err = codec.encode(v)
+			if err != nil {
+				return fmt.Errorf("cannot export '%s': %w", key, err)
+			}
+			if expiresAt != 0 {
+				t := time.Unix(int64(expiresAt), 0).UTC()
+				rec.ExpiresAt = &t
+			}
+			err = enc.Encode(rec)
+			if err != nil {
+				return err
+			}
+			n++
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, bw.Flush()
+}
+
+// readExport reads and validates the whole file, so nothing is written if any of the records is invalid.
+func readExport(r io.Reader) ([]*importEntry, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxExportLine)
+	if !s.Scan() {
+		if s.Err() != nil {
+			return nil, s.Err()
+		}
+		return nil, errors.New("empty export file")
+	}
+	var h exportHeader
+	err := json.Unmarshal(s.Bytes(), &h)
+	if err != nil || h.Format != exportFormat {
+		return nil, errors.New("the file is not a database export, the header is missing")
+	}
+	if h.SchemaVersion != len(migrations) {
+		return nil, fmt.Errorf("export schema version %v doesn't match the database schema version %v",
+			h.SchemaVersion, len(migrations))
+	}
+	var entries []*importEntry
+	line := 1
+	now := time.Now()
+	for s.Scan() {
+		line++
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+		var rec exportRecord
+		err = json.Unmarshal(s.Bytes(), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", line, err)
+		}
+		codec, ok := exportCodecs[keyNamespace(rec.Key)]
+		if !ok || rec.Key == string(dbKeySchemaVersion) {
+			return nil, fmt.Errorf("line %v: unexpected key '%s'", line, rec.Key)
+		}
+		if len(rec.Value) == 0 {
+			return nil, fmt.Errorf("line %v: '%s' has no value", line, rec.Key)
+		}
+		v, err := codec.decode(rec.Value)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid value of '%s': %w", line, rec.Key, err)
+		}
+		e := &importEntry{key: []byte(rec.Key), value: v}
+		if rec.ExpiresAt != nil {
+			if !rec.ExpiresAt.After(now) {
+				continue
+			}
+			e.expiresAt = uint64(rec.ExpiresAt.Unix())
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// Import writes the keys exported by Export, mode is ImportMerge or ImportReplace. The records are validated
+// before anything is written. It returns the number of the keys written, the expired ones are skipped.
+func (d *Db) Import(r io.Reader, mode string) (int, error) {
+	if mode != ImportMerge && mode != ImportReplace {
+		return 0, fmt.Errorf("unknown import mode '%s'", mode)
+	}
+	entries, err := readExport(r)
+	if err != nil {
+		return 0, err
+	}
+	if mode == ImportReplace {
+		err = d.b.DropAll()
+		if err != nil {
+			return 0, err
+		}
+		err = d.storeSchemaVersion(len(migrations))
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = d.batch(len(entries), func(txn kvTxn, i int) error {
+		err := d.writeImportEntry(txn, entries[i])
+		if err != nil {
+			return fmt.Errorf("cannot import '%s': %w", entries[i].key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Info().Int("keys", len(entries)).Str("mode", mode).Msg("database imported")
+	return len(entries), nil
+}
+
+// writeImportEntry writes the key, image records go through setImage to keep the seen index in sync,
+// they get the retention of their source.
+func (d *Db) writeImportEntry(txn kvTxn, e *importEntry) error {
+	if !strings.HasPrefix(string(e.key), nsUrl) {
+		return txn.SetEntry(e.key, e.value, e.expiresAt)
+	}
+	url := strings.TrimPrefix(string(e.key), nsUrl)
+	r, err := decodeImageRecord(url, e.value)
+	if err != nil {
+		return err
+	}
+	old, err := readImage(txn, e.key, url)
+	if err != nil && !errors.Is(err, errKeyNotFound) {
+		return err
+	}
+	return d.setImage(txn, old, r)
+}
+
+// ExportDb writes the database export to the file or to stdout if the path is empty.
+func (g *Gorobei) ExportDb(path string) error {
+	if path == "" {
+		_, err := g.d.Export(os.Stdout)
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	n, err := g.d.Export(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	log.Info().Int("keys", n).Str("path", path).Msg("database exported")
+	return f.Close()
+}
+
+// ImportDb reads the database export from the file.
+func (g *Gorobei) ImportDb(path string, mode string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = g.d.Import(f, mode)
+	return err
+}
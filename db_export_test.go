@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fillTestDb(t *testing.T, d *Db) {
+	seen := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, d.StoreImage(&ImageRecord{Url: "http://img/1.jpg?a=1&b=2", FirstSeen: seen, Status: ImagePosted,
+		Posts: []*ImagePost{{ChatId: -100, MessageId: 1, PostedAt: seen}}}))
+	require.NoError(t, d.StoreUrlSent("http://img/1.jpg?a=1&b=2", "", -100, 1))
+	require.NoError(t, d.StorePost("http://img/1.jpg?a=1&b=2", &SentMessage{ChatId: -100, MessageId: 1}))
+	require.NoError(t, d.StoreUser(&User{Id: 100, Username: "John", LastSeen: seen}))
+	require.NoError(t, d.StoreDailyReport(&DailyReport{Run: 1, SentAt: seen}))
+	require.NoError(t, d.StoreChatPause(-200, "kicked"))
+	ok, err := d.TakeQuota(100, "2022-05-01", 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestDb_ExportImport(t *testing.T) {
+	d, deferFunc := testInit(t)
+	defer deferFunc()
+	fillTestDb(t, d)
+
+	var buf bytes.Buffer
+	n, err := d.Export(&buf)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, n+1)
+	var h exportHeader
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &h))
+	assert.Equal(t, exportFormat, h.Format)
+	assert.Equal(t, len(migrations), h.SchemaVersion)
+	assert.Contains(t, buf.String(), `{"key":"url_http://img/1.jpg?a=1&b=2","value":{"first_seen":"2022-05-01T10:00:00Z"`)
+	assert.Contains(t, buf.String(), `{"key":"username_john","value":100}`)
+	assert.NotContains(t, buf.String(), nsSeen)
+	var quota exportRecord
+	for _, l := range lines {
+		if strings.Contains(l, nsQuota) {
+			require.NoError(t, json.Unmarshal([]byte(l), &quota))
+		}
+	}
+	require.NotNil(t, quota.ExpiresAt)
+
+	d2, err := OpenDb("memory://")
+	require.NoError(t, err)
+	defer d2.Close()
+	require.NoError(t, d2.StoreChatPause(-300, "kicked"))
+
+	// merge keeps the keys missing in the export
+	imported, err := d2.Import(bytes.NewReader(buf.Bytes()), ImportMerge)
+	require.NoError(t, err)
+	assert.Equal(t, n, imported)
+	_, err = d2.ReadChatPause(-300)
+	require.NoError(t, err)
+
+	// replace drops them
+	_, err = d2.Import(bytes.NewReader(buf.Bytes()), ImportReplace)
+	require.NoError(t, err)
+	_, err = d2.ReadChatPause(-300)
+	require.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, dbKeys(t, d), dbKeys(t, d2))
+
+	var urls []string
+	require.NoError(t, d2.ReadImages(time.Time{}, time.Time{}, func(r *ImageRecord) error {
+		urls = append(urls, r.Url)
+		return nil
+	}))
+	assert.Equal(t, []string{"http://img/1.jpg?a=1&b=2"}, urls)
+	u, err := d2.ReadUser(100)
+	require.NoError(t, err)
+	assert.Equal(t, "John", u.Username)
+	ok, err := d2.TakeQuota(100, "2022-05-01", 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestDb_ImportInvalid(t *testing.T) {
+	d, deferFunc := testInit(t)
+	defer deferFunc()
+	require.NoError(t, d.StoreChatPause(-300, "kicked"))
+	header := fmt.Sprintf(`{"format":"gorobei-db","schema_version":%v}`+"\n", len(migrations))
+	valid := `{"key":"paused_-100","value":"kicked"}` + "\n"
+
+	for name, data := range map[string]string{
+		"no header":      valid,
+		"old schema":     `{"format":"gorobei-db","schema_version":1}` + "\n" + valid,
+		"unknown ns":     header + valid + `{"key":"other_1","value":1}` + "\n",
+		"seen index":     header + `{"key":"seen_00000000000000000000_http://img","value":""}` + "\n",
+		"invalid status": header + `{"key":"url_http://img","value":{"status":"done"}}` + "\n",
+		"unknown field":  header + `{"key":"user_100","value":{"Id":100,"Name":"john"}}` + "\n",
+		"not a number":   header + `{"key":"username_john","value":"100"}` + "\n",
+		"no value":       header + `{"key":"paused_-100"}` + "\n",
+	} {
+		_, err := d.Import(strings.NewReader(data), ImportReplace)
+		assert.Error(t, err, name)
+	}
+	// nothing has been written
+	_, err := d.ReadChatPause(-100)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = d.ReadChatPause(-300)
+	require.NoError(t, err)
+
+	_, err = d.Import(strings.NewReader(header+valid), "append")
+	require.Error(t, err)
+	_, err = d.Import(strings.NewReader(header+valid+"\n"), ImportMerge)
+	require.NoError(t, err)
+	reason, err := d.ReadChatPause(-100)
+	require.NoError(t, err)
+	assert.Equal(t, "kicked", reason)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"os"
@@ -10,26 +12,41 @@ import (
 
 func testInit(t *testing.T) (*Db, func()) {
 	initLog(false)
-	path := "./gorobei_test_db"
-	d, err := OpenDb(path)
+	d, err := OpenDb("memory://")
 	require.NoError(t, err)
 	return d, func() {
 		d.Close()
-		os.RemoveAll(path)
 	}
 }
 
 func TestDb_Set(t *testing.T) {
 	d, deferFunc := testInit(t)
 	defer deferFunc()
-	err := d.ReadUrlProcessed("test", 1)
+	seen := time.Unix(1000, 0).UTC()
+	err := d.StoreImage(&ImageRecord{Url: "test", FirstSeen: seen, Source: "http://page", Status: ImagePending})
 	require.NoError(t, err)
-	v, err := d.StoreUrlProcessed("test")
+	err = d.UpdateImage("test", func(r *ImageRecord) {
+		r.Status = ImagePosted
+		r.Attempts++
+		r.Posts = append(r.Posts, &ImagePost{ChatId: 1, MessageId: 2, PostedAt: seen})
+	})
 	require.NoError(t, err)
-	assert.Equal(t, byte(1), v)
-	v, err = d.StoreUrlProcessed("test1")
+	r, err := d.ReadImage("test")
+	require.NoError(t, err)
+	assert.Equal(t, &ImageRecord{Url: "test", FirstSeen: seen, Source: "http://page", Status: ImagePosted, Attempts: 1,
+		Posts: []*ImagePost{{ChatId: 1, MessageId: 2, PostedAt: seen}}}, r)
+	_, err = d.ReadImage("test1")
 	assert.ErrorIs(t, err, ErrNotFound)
 
+	// new record is created by update
+	require.NoError(t, d.UpdateImage("test2", func(r *ImageRecord) { r.Attempts++ }))
+	r, err = d.ReadImage("test2")
+	require.NoError(t, err)
+	assert.Equal(t, ImagePending, r.Status)
+	assert.Equal(t, 1, r.Attempts)
+
+	_, err = decodeImageRecord("test", []byte{9, '{', '}'})
+	assert.Error(t, err)
 }
 
 func TestDb_SetGetUserID(t *testing.T) {
@@ -55,6 +72,40 @@ func TestDb_SetGetUserID(t *testing.T) {
 
 }
 
+func TestDb_StoreUser(t *testing.T) {
+	d, deferFunc := testInit(t)
+	defer deferFunc()
+
+	u := &User{Id: 100, Username: "OldName", FirstName: "John", LastSeen: time.Unix(1000, 0).UTC()}
+	require.NoError(t, d.StoreUser(u))
+	id, err := d.ReadUserId("oldname")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), id)
+
+	// rename
+	u = &User{Id: 100, Username: "NewName", FirstName: "John", LastName: "Smith", LastSeen: time.Unix(2000, 0).UTC()}
+	require.NoError(t, d.StoreUser(u))
+	_, err = d.ReadUserId("oldname")
+	assert.ErrorIs(t, err, ErrNotFound)
+	id, err = d.ReadUserId("newname")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), id)
+	stored, err := d.ReadUser(100)
+	require.NoError(t, err)
+	assert.Equal(t, u, stored)
+
+	// another user takes the name, the first one renames again and must not remove it
+	require.NoError(t, d.StoreUser(&User{Id: 200, Username: "NewName"}))
+	require.NoError(t, d.StoreUser(&User{Id: 100, Username: "Third"}))
+	id, err = d.ReadUserId("newname")
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), id)
+
+	assert.Error(t, d.StoreUser(&User{Id: 300}))
+	_, err = d.ReadUser(300)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
 func TestDb_DailyReport(t *testing.T) {
 	d, deferFunc := testInit(t)
 	defer deferFunc()
@@ -73,4 +124,140 @@ func TestDb_DailyReport(t *testing.T) {
 	stored, err := d.ReadDailyReport()
 	require.NoError(t, err)
 	require.Equal(t, r, *stored)
-}
\ No newline at end of file
+}
+
+func TestDb_ImageFile(t *testing.T) {
+	d, deferFunc := testInit(t)
+	defer deferFunc()
+	_, err := d.ReadImageFile("http://img/1.jpg")
+	require.ErrorIs(t, err, ErrNotFound)
+	m := SentMessage{ChatId: -100, MessageId: 12, FileId: "AgACAgIAAxk"}
+	require.NoError(t, d.StoreImageFile("http://img/1.jpg", &m))
+	stored, err := d.ReadImageFile("http://img/1.jpg")
+	require.NoError(t, err)
+	require.Equal(t, m, *stored)
+}
+
+// loadFixture creates the database having the raw keys of the fixture file, the schema is not migrated.
+func loadFixture(t *testing.T, fixture string, path string) {
+	data, err := os.ReadFile(fixture)
+	require.NoError(t, err)
+	var keys map[string]string
+	require.NoError(t, json.Unmarshal(data, &keys))
+	b, err := badger.Open(badger.DefaultOptions(path).WithLogger(nil))
+	require.NoError(t, err)
+	err = b.Update(func(txn *badger.Txn) error {
+		for k, v := range keys {
+			if err := txn.Set([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+}
+
+func TestDb_MigrateFixture(t *testing.T) {
+	initLog(false)
+	path := t.TempDir()
+	loadFixture(t, "testdata/db_v0.json", path)
+
+	d, err := OpenDb(path)
+	require.NoError(t, err)
+	defer d.Close()
+
+	version, err := d.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	r, err := d.ReadImage("https://i.imgur.com/processed.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, ImageArchived, r.Status)
+	r, err = d.ReadImage("https://i.imgur.com/forgotten.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, ImageForgotten, r.Status)
+	// the url looking like an internal key
+	r, err = d.ReadImage("http://example.com/username_john")
+	require.NoError(t, err)
+	assert.Equal(t, ImageArchived, r.Status)
+
+	report, err := d.ReadDailyReport()
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Run)
+	assert.Equal(t, "timeout", report.LastError)
+
+	id, err := d.ReadUserId("john")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), id)
+	v, err := d.ReadUrlSent("https://i.imgur.com/new.jpg", 100)
+	require.NoError(t, err)
+	assert.Equal(t, byte(1), v)
+
+	assert.Equal(t, []string{
+		"meta_daily_report",
+		"meta_schema_version",
+		"seen_00000000000000000000_http://example.com/username_john",
+		"seen_00000000000000000000_https://i.imgur.com/forgotten.jpg",
+		"seen_00000000000000000000_https://i.imgur.com/processed.jpg",
+		"sent_100_https://i.imgur.com/new.jpg",
+		"url_http://example.com/username_john",
+		"url_https://i.imgur.com/forgotten.jpg",
+		"url_https://i.imgur.com/processed.jpg",
+		"username_john",
+	}, dbKeys(t, d))
+}
+
+// An interrupted migration runs again on the next start, so each migration applied twice
+// must leave the same keys as applied once.
+func TestDb_MigrationsIdempotent(t *testing.T) {
+	initLog(false)
+	path := t.TempDir()
+	loadFixture(t, "testdata/db_v0.json", path)
+
+	b, err := badger.Open(badger.DefaultOptions(path).WithLogger(nil))
+	require.NoError(t, err)
+	d := &Db{b: &badgerEngine{b: b}}
+	defer d.Close()
+	for _, m := range migrations {
+		require.NoError(t, m.apply(d), m.name)
+		keys := dbKeys(t, d)
+		require.NoError(t, m.apply(d), m.name)
+		assert.Equal(t, keys, dbKeys(t, d), m.name)
+	}
+}
+
+func dbKeys(t *testing.T, d *Db) []string {
+	var res []string
+	err := d.b.View(func(txn kvTxn) error {
+		return txn.Scan(nil, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			res = append(res, string(key))
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	return res
+}
+
+func TestDb_MigrateNewerSchema(t *testing.T) {
+	d, deferFunc := testInit(t)
+	defer deferFunc()
+	require.NoError(t, d.storeSchemaVersion(len(migrations)+1))
+	require.Error(t, d.Migrate())
+}
+
+func TestDb_SuggestWithQuota(t *testing.T) {
+	d, deferFunc := testInit(t)
+	defer deferFunc()
+	ok, err := d.SuggestWithQuota(&Suggestion{UserId: 100, MessageId: 1}, "2022-05-01", 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	// nothing is stored over the quota
+	ok, err = d.SuggestWithQuota(&Suggestion{UserId: 100, MessageId: 2}, "2022-05-01", 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	queue, err := d.ReadSuggestions()
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, 1, queue[0].MessageId)
+}
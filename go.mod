@@ -8,6 +8,9 @@ require (
 	github.com/mymmrac/telego v0.4.1
 	github.com/phuslu/log v1.0.75
 	github.com/stretchr/testify v1.7.0
+	github.com/valyala/fasthttp v1.31.0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/sys v0.0.0-20210514084401-e8d321eab015
 )
 
 require (
@@ -30,9 +33,8 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
-	github.com/valyala/fasthttp v1.31.0 // indirect
 	go.opencensus.io v0.22.5 // indirect
 	golang.org/x/net v0.0.0-20210510120150-4163338589ed // indirect
-	golang.org/x/sys v0.0.0-20210514084401-e8d321eab015 // indirect
+	golang.org/x/text v0.3.6 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )
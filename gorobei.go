@@ -3,49 +3,104 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/mymmrac/telego"
 	"github.com/phuslu/log"
 	"gorobei/clock"
 	"gorobei/utils"
 	"os"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Gorobei struct {
-	d       *Db
+	d       Store
 	chat    string
 	chatId  int64
-	admin   string
-	adminId int64
+	admins  []*Admin
 	tg      Telegram
 	clock   clock.Clock
 	fetcher HttpFetcher
+	config  *Config
+	// mu serializes posting and the chat migrations between the fetch loop and the update handler
+	mu sync.Mutex
 }
 
-var ErrImageAlreadyProcessed = errors.New("image has been processed already")
+var (
+	ErrImageAlreadyProcessed = errors.New("image has been processed already")
+	ErrChatsPaused           = errors.New("posting to the chats is paused")
+)
 
 func (g *Gorobei) Init() error {
-	chat, err := g.tg.ChatInfo(g.chat)
+	var err error
+	g.chatId, err = g.resolveChat(g.chat)
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot get info of the chat '%s': %w", g.chat, err)
+	}
+	for _, d := range g.config.Destinations() {
+		d.chatId, err = g.resolveChat(d.Chat)
+		if err != nil {
+			return fmt.Errorf("cannot get info of the chat '%s': %w", d.Chat, err)
+		}
+		if d.MaxRpm > 0 || d.MaxRps > 0 {
+			g.tg.SetRateLimit(d.chatId, d.MaxRpm, d.MaxRps)
+		}
 	}
-	g.chatId = chat.ID
 	err = g.tg.DoUpdates()
 	if err != nil {
 		return err
 	}
 
-	if g.admin != "" {
-		g.adminId, err = g.d.ReadUserId(g.admin)
+	return g.initAdmins()
+}
+
+// resolveChat returns the ID of the chat referenced by ID, '@name', 't.me/name' link or user name.
+// The IDs of the named chats are cached, so the chat info is requested once. The offline commands
+// have no Telegram client, they resolve the names cached by the previous runs only.
+func (g *Gorobei) resolveChat(chat string) (int64, error) {
+	id, name, err := ParseChat(chat)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case id != 0:
+	case name[0] != '@':
+		id, err = g.d.ReadUserId(name)
 		if err != nil {
-			if errors.Is(err, ErrNotFound) {
-				log.Error().Err(err).Msg("cannot obtain admin user ID. Admin notifications will be disabled.")
-			} else {
-				return err
+			return 0, fmt.Errorf("cannot find ID of the user '%s': %w", name, err)
+		}
+	default:
+		id, err = g.d.ReadChatId(name)
+		if errors.Is(err, ErrNotFound) && g.tg == nil {
+			return 0, fmt.Errorf("the ID of the chat '%s' is unknown, use the numeric ID: %w", name, err)
+		}
+		if errors.Is(err, ErrNotFound) {
+			var info *telego.Chat
+			info, err = g.tg.ChatInfo(name)
+			if err != nil {
+				return 0, err
 			}
+			id = info.ID
+			err = g.d.StoreChatId(name, id)
+		}
+		if err != nil {
+			return 0, err
 		}
 	}
-	return nil
+	return g.migratedChatId(id)
+}
+
+// migratedChatId returns the ID of the supergroup if the chat has been migrated.
+func (g *Gorobei) migratedChatId(chatId int64) (int64, error) {
+	to, err := g.d.ReadChatMigration(chatId)
+	if errors.Is(err, ErrNotFound) {
+		return chatId, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return to, nil
 }
 
 func (g *Gorobei) Close() error {
@@ -71,6 +126,9 @@ func (g *Gorobei) Fetch(url string, limit int) error {
 	re := regexp.MustCompile(`(?si)<div class="sape_context"><img src=["'](.*?)["']`)
 	ma := re.FindAllStringSubmatch(string(body), -1)
 	utils.ReverseSlice(ma)
+	g.mu.Lock()
+	dests := g.destinations(url)
+	g.mu.Unlock()
 	var (
 		total, skipped, errc int
 		lastError            string
@@ -79,16 +137,17 @@ func (g *Gorobei) Fetch(url string, limit int) error {
 		if len(m) == 2 && m[1] != `https://i.imgur.com/sMhpFyR.jpg` {
 			src := m[1]
 			log.Info().Str("src", src).Msg("image found")
-			err = g.processImage(src)
+			err = g.processImage(src, url, dests)
 			if err != nil {
-				if errors.Is(err, ErrImageAlreadyProcessed) {
+				if errors.Is(err, ErrImageAlreadyProcessed) || errors.Is(err, ErrChatsPaused) {
 					skipped += 1
 				} else {
 					lastError = err.Error()
 					errc += 1
 					log.Error().Err(err).Str("src", src).Msg("cannot process image")
-					msg := fmt.Sprintf("Error occured during processing the image!\n[image](%s)\n\n__error__:\n```\n%s\n```", src, err.Error())
-					err2 := g.SendAdminMessage(msg)
+					msg := NewMessage().Plain("Error occured during processing the ").Link("image", src).Plain("!\n\n").
+						Underline("error").Plain(":\n").Pre(err.Error(), "")
+					err2 := g.Notify(NotifyFailure, SeverityError, msg)
 					if err2 != nil {
 						log.Error().Err(err2).Msg("cannot send admin message")
 					}
@@ -110,28 +169,40 @@ func (g *Gorobei) Fetch(url string, limit int) error {
 	}
 	// notify admin about errors or new images posted
 	if errc > 0 || (total-skipped) > 0 {
-		msg := fmt.Sprintf("Fetching images from the [page](%s) completed.\nTotal: %v\nNew: %v\nSkipped: %v\nErrors: %v", url, total, total-skipped, skipped, errc)
-		err = g.SendAdminMessage(msg)
+		msg := NewMessage().Plain("Fetching images from the ").Link("page", url).
+			Plainf(" completed.\nTotal: %v\nNew: %v\nSkipped: %v\nErrors: %v", total, total-skipped, skipped, errc)
+		severity := SeverityInfo
+		if errc > 0 {
+			severity = SeverityWarning
+		}
+		err = g.Notify(NotifySummary, severity, msg)
 		return err
 	}
 	return nil
 }
 
-func (g *Gorobei) FormatDailyReport(r *DailyReport) string {
-	msg := utils.Bt(
-		`*Daily report.*
-
-The bot has run *%v* times.
-New images posted: *%v*
-Images found (during last run): *%v*
-Errors (during last run): *%v*
-Last error:
-³³³
-%v
-³³³`)
-	return fmt.Sprintf(msg, r.Run, r.Posted, r.Total, r.Errors, r.LastError)
+func (g *Gorobei) FormatDailyReport(r *DailyReport) *MessageBuilder {
+	msg := NewMessage().Bold("Daily report.").Plain("\n\n").
+		Plain("The bot has run ").Bold(fmt.Sprint(r.Run)).Plain(" times.\n").
+		Plain("New images posted: ").Bold(fmt.Sprint(r.Posted)).Plain("\n").
+		Plain("Images found (during last run): ").Bold(fmt.Sprint(r.Total)).Plain("\n").
+		Plain("Errors (during last run): ").Bold(fmt.Sprint(r.Errors)).Plain("\n").
+		Plain("Last error:\n").Pre(r.LastError, "")
+	top, err := g.d.TopVoted(topVotedInReport)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot read top voted images")
+	}
+	if len(top) > 0 {
+		msg.Plain("\n\n").Bold("Top voted images:")
+		for i, t := range top {
+			msg.Plainf("\n%v. ", i+1).Link("image", t.Url).Plainf(" 👍 %v 👎 %v", t.Up, t.Down)
+		}
+	}
+	return msg
 }
 
+const topVotedInReport = 5
+
 func (g *Gorobei) ReadOrCreateDailyReport() (*DailyReport, error) {
 	r, err := g.d.ReadDailyReport()
 	if err != nil {
@@ -159,8 +230,11 @@ func (g *Gorobei) UpdateAndSendDailyReport(total, skipped, errc int, lastError s
 	// if Now() > planned send date?
 	if g.clock.Now().Sub(planned) > 0 {
 		// send the report
-		err = g.SendAdminMessage(g.FormatDailyReport(r))
+		err = g.Notify(NotifyReport, SeverityInfo, g.FormatDailyReport(r))
+		// the digests do not depend on the report delivery
+		g.SendDigests()
 		if err != nil {
+			log.Error().Err(err).Msg("cannot send the daily report")
 			return err
 		}
 		// store new values
@@ -182,46 +256,458 @@ func (g *Gorobei) UpdateAndSendDailyReport(total, skipped, errc int, lastError s
 	return g.d.StoreDailyReport(r)
 }
 
-func (g *Gorobei) processImage(src string) error {
-	done, err := g.d.StoreUrlProcessed(src)
+// destinations returns the chats configured for the source url, the default chat is used if there are none.
+func (g *Gorobei) destinations(url string) []*Destination {
+	if src := g.config.Source(url); src != nil {
+		return src.Destinations
+	}
+	return []*Destination{{Chat: g.chat, chatId: g.chatId}}
+}
+
+func (g *Gorobei) processImage(src string, source string, dests []*Destination) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.postImage(src, source, "", dests, g.imageToSend)
+}
+
+// postImage sends the image to the destinations it hasn't been sent to. The caption overrides the destination
+// caption template if set. The image is obtained by load only if there are chats to send it to.
+// The caller must hold g.mu.
+func (g *Gorobei) postImage(src string, source string, caption string, dests []*Destination, load func(src string) (*Image, error)) error {
+	rec, err := g.d.ReadImage(src)
 	if err != nil && !errors.Is(err, ErrNotFound) {
 		return err
 	}
-	if done == 1 {
+	if rec != nil && rec.Status == ImageArchived {
+		// processed before images were tracked per destination
 		log.Info().Str("src", src).Msg("image has been processed already")
 		return ErrImageAlreadyProcessed
 	}
-	path, err := g.fetcher.FetchImage(src)
+	var (
+		pending []*Destination
+		paused  int
+	)
+	for _, d := range dests {
+		var done byte
+		done, err = g.d.ReadUrlSent(src, d.chatId)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if done == 1 {
+			continue
+		}
+		_, err = g.d.ReadChatPause(d.chatId)
+		if err == nil {
+			paused++
+			continue
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		pending = append(pending, d)
+	}
+	if len(pending) == 0 {
+		if paused > 0 {
+			log.Info().Str("src", src).Int("paused", paused).Msg("posting to the chats is paused")
+			return ErrChatsPaused
+		}
+		log.Info().Str("src", src).Msg("image has been processed already")
+		return ErrImageAlreadyProcessed
+	}
+	hash, posts, err := g.sendImage(src, source, caption, len(dests), pending, load)
+	if errors.Is(err, ErrImageAlreadyProcessed) {
+		return err
+	}
+	if err == nil && paused > 0 {
+		// the image stays pending until the paused chats get it
+		err = ErrChatsPaused
+	}
+	err2 := g.recordAttempt(src, source, hash, posts, err)
+	if err2 != nil {
+		log.Error().Err(err2).Str("src", src).Msg("cannot update image record")
+	}
+	return err
+}
+
+// recordAttempt updates the image record with the result of the attempt to send the image.
+func (g *Gorobei) recordAttempt(src string, source string, hash string, posts []*ImagePost, sendErr error) error {
+	now := g.clock.Now()
+	return g.d.UpdateImage(src, func(r *ImageRecord) {
+		if r.FirstSeen.IsZero() {
+			r.FirstSeen = now
+		}
+		if r.Source == "" {
+			r.Source = source
+		}
+		if hash != "" {
+			r.Hash = hash
+		}
+		r.Attempts++
+		if len(posts) > 0 && r.PostedAt.IsZero() {
+			r.PostedAt = now
+		}
+		r.Posts = append(r.Posts, posts...)
+		switch {
+		case errors.Is(sendErr, ErrChatsPaused):
+			r.Status = ImagePending
+			r.LastError = ""
+		case sendErr != nil:
+			r.Status = ImageFailed
+			r.LastError = sendErr.Error()
+		default:
+			r.Status = ImagePosted
+			r.LastError = ""
+		}
+	})
+}
+
+// recordDuplicate marks the url as the duplicate of the first one having the same content.
+func (g *Gorobei) recordDuplicate(src string, source string, hash string, first string) error {
+	now := g.clock.Now()
+	return g.d.UpdateImage(src, func(r *ImageRecord) {
+		if r.FirstSeen.IsZero() {
+			r.FirstSeen = now
+		}
+		if r.Source == "" {
+			r.Source = source
+		}
+		r.Hash = hash
+		r.DuplicateOf = first
+		r.Status = ImageDuplicate
+		r.LastError = ""
+	})
+}
+
+// sendImage sends the image to the pending destinations, it returns the content hash and the messages sent.
+func (g *Gorobei) sendImage(src string, source string, caption string, total int, pending []*Destination,
+	load func(src string) (*Image, error)) (string, []*ImagePost, error) {
+	image, err := load(src)
+	if err != nil {
+		return "", nil, err
+	}
+	var hash string
+	if image.Path != "" {
+		defer os.Remove(image.Path)
+		var first string
+		hash, first, pending, err = g.dedupeContent(src, source, image.Path, pending)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(pending) == 0 {
+			// the record tells why the url hasn't been posted
+			err = g.recordDuplicate(src, source, hash, first)
+			if err != nil {
+				return hash, nil, err
+			}
+			return hash, nil, ErrImageAlreadyProcessed
+		}
+	}
+
+	var (
+		failed []string
+		posts  []*ImagePost
+		paused int
+	)
+	for _, d := range pending {
+		var sent *SentMessage
+		sent, err = g.sendImageTo(d, image, src, source, caption)
+		var migrated *ChatMigratedError
+		if errors.As(err, &migrated) {
+			err = g.migrateChat(d, migrated.To)
+			if err != nil {
+				return hash, posts, err
+			}
+			sent, err = g.sendImageTo(d, image, src, source, caption)
+		}
+		var unavailable *ChatUnavailableError
+		if errors.As(err, &unavailable) {
+			// the image stays pending and is sent after the chat is resumed
+			err = g.pauseChat(d, unavailable.Reason)
+			if err != nil {
+				return hash, posts, err
+			}
+			paused++
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Str("chat", d.Chat).Msg("cannot send fetched image to the chat")
+			failed = append(failed, fmt.Sprintf("%s: %s", d.Chat, err.Error()))
+			continue
+		}
+		if image.FileId == "" && sent.FileId != "" {
+			// the rest of the chats get the uploaded file
+			err = g.d.StoreImageFile(src, sent)
+			if err != nil {
+				return hash, posts, err
+			}
+			image.FileId = sent.FileId
+		}
+		posts = append(posts, &ImagePost{ChatId: sent.ChatId, MessageId: sent.MessageId, PostedAt: g.clock.Now()})
+		err = g.d.StoreUrlSent(src, source, d.chatId, 1)
+		if err != nil {
+			return hash, posts, err
+		}
+		err = g.d.StorePost(src, sent)
+		if err != nil {
+			return hash, posts, err
+		}
+	}
+	if source != "" && len(pending) == total && len(posts) > 0 && image.FileId != "" {
+		// the image is new, send it to the subscribers
+		g.notifySubscribers(source, src, image.FileId)
+	}
+	if len(failed) > 0 {
+		return hash, posts, fmt.Errorf("cannot send image to %v of %v chats:\n%s", len(failed), len(pending), strings.Join(failed, "\n"))
+	}
+	if paused > 0 {
+		return hash, posts, ErrChatsPaused
+	}
+	return hash, posts, nil
+}
+
+// migrateChat switches the destination to the supergroup the group has been upgraded to.
+func (g *Gorobei) migrateChat(d *Destination, to int64) error {
+	from := d.chatId
+	log.Warn().Str("chat", d.Chat).Int64("from", from).Int64("to", to).Msg("chat has been migrated to supergroup")
+	err := g.d.MigrateChat(from, to)
 	if err != nil {
 		return err
 	}
-	defer os.Remove(path)
-	err = g.SendChatImage(path, "")
+	d.chatId = to
+	if g.chatId == from {
+		g.chatId = to
+	}
+	if d.MaxRpm > 0 || d.MaxRps > 0 {
+		g.tg.SetRateLimit(d.chatId, d.MaxRpm, d.MaxRps)
+	}
+	return nil
+}
+
+// pauseChat stops posting to the chat the bot cannot post to and alerts the admins once.
+func (g *Gorobei) pauseChat(d *Destination, reason string) error {
+	log.Error().Str("chat", d.Chat).Str("reason", reason).Msg("chat is unavailable, posting is paused")
+	err := g.d.StoreChatPause(d.chatId, reason)
 	if err != nil {
-		log.Error().Err(err).Msg("cannot send fetched image to the chat")
 		return err
 	}
-	err = g.d.ReadUrlProcessed(src, 1)
-	return err
+	msg := NewMessage().Plain("Posting to the chat ").Code(d.Chat).Plain(" is paused: ").Bold(reason).
+		Plain(".\nRestore the bot permissions and run ").Code("resume-chat " + d.Chat).Plain(" to continue.")
+	err = g.Notify(NotifyFailure, SeverityError, msg)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot send admin message")
+	}
+	return nil
+}
 
+// ResumeChat resumes posting to the chat paused because the bot had been removed or lost its rights.
+func (g *Gorobei) ResumeChat(chat string) error {
+	chatId, err := g.resolveChat(chat)
+	if err != nil {
+		return err
+	}
+	_, err = g.d.ReadChatPause(chatId)
+	if err != nil {
+		return fmt.Errorf("chat '%s' is not paused: %w", chat, err)
+	}
+	return g.d.DeleteChatPause(chatId)
+}
+
+// imageToSend returns the file_id of the image uploaded earlier, the image is downloaded otherwise.
+func (g *Gorobei) imageToSend(src string) (*Image, error) {
+	f, err := g.d.ReadImageFile(src)
+	if err == nil && f.FileId != "" {
+		log.Info().Str("src", src).Str("file_id", f.FileId).Msg("reusing uploaded file")
+		return &Image{FileId: f.FileId}, nil
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	path, err := g.fetcher.FetchImage(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Path: path}, nil
+}
+
+// dedupeContent returns the destinations the image with the same content hasn't been sent to under another url.
+// The content hash and the url the content was first seen under are returned as well.
+func (g *Gorobei) dedupeContent(src string, source string, path string, dests []*Destination) (string, string, []*Destination, error) {
+	hash, err := utils.FileHash(path)
+	if err != nil {
+		return "", "", nil, err
+	}
+	first, err := g.d.StoreImageHash(hash, src, source)
+	if err != nil || first == src {
+		return hash, first, dests, err
+	}
+	var res []*Destination
+	for _, d := range dests {
+		done, err := g.d.ReadUrlSent(first, d.chatId)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return "", "", nil, err
+		}
+		if done != 1 {
+			res = append(res, d)
+			continue
+		}
+		log.Info().Str("src", src).Str("duplicate_of", first).Str("chat", d.Chat).Msg("image has been sent under another url")
+		err = g.d.StoreUrlSent(src, source, d.chatId, 1)
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+	return hash, first, res, nil
+}
+
+func (g *Gorobei) sendImageTo(d *Destination, image *Image, src string, source string, caption string) (*SentMessage, error) {
+	if caption == "" {
+		var err error
+		caption, err = d.FormatCaption(&CaptionData{Src: src, Source: source, Chat: d.Chat})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g.tg.SendImage("", d.chatId, image, caption, g.postOptions(d, source))
+}
+
+// postOptions returns the destination options applied to the current post.
+func (g *Gorobei) postOptions(d *Destination, source string) *PostOptions {
+	if d.Options == nil {
+		return nil
+	}
+	opts := *d.Options
+	opts.sourceUrl = source
+	if opts.QuietHours.Contains(g.clock.Now()) {
+		opts.DisableNotification = true
+	}
+	return &opts
 }
 
 func (g *Gorobei) SendChatImage(image string, caption string) error {
-	return g.tg.SendImage("", g.chatId, image, caption)
+	_, err := g.tg.SendImage("", g.chatId, &Image{Path: image}, caption, nil)
+	return err
 }
 
 func (g *Gorobei) SendChatMessage(message string) error {
 	return g.tg.SendMessageText("", g.chatId, message)
 }
 
+// SendAdminMessage sends the markdown message to all the admins.
 func (g *Gorobei) SendAdminMessage(message string) error {
-	if g.adminId == 0 {
-		return nil
+	return g.notify(NotifyDirect, SeverityInfo, func(id int64) error {
+		return g.tg.SendMessageMarkdown("", id, message)
+	})
+}
+
+// ForgetImg removes the url from the db, the image will be posted again during the next fetch.
+// The messages posted earlier are deleted if deletePosts is set.
+func (g *Gorobei) ForgetImg(url string, deletePosts bool) error {
+	_, err := g.d.ReadImage(url)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("no record of '%s': %w", url, err)
+	}
+	if err != nil {
+		return err
 	}
+	if deletePosts {
+		err := g.DeletePost(url)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	var source string
+	err = g.d.UpdateImage(url, func(r *ImageRecord) {
+		r.Status = ImageForgotten
+		source = r.Source
+	})
+	if err != nil {
+		return err
+	}
+	dests := append(g.config.Destinations(), &Destination{Chat: g.chat, chatId: g.chatId})
+	for _, d := range dests {
+		err = g.d.StoreUrlSent(url, source, d.chatId, 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return g.tg.SendMessageMarkdown("", g.adminId, message)
+// DeletePost deletes the messages the image url was posted as from all chats.
+func (g *Gorobei) DeletePost(url string) error {
+	posts, err := g.d.ReadPosts(url)
+	if err != nil {
+		return err
+	}
+	if len(posts) == 0 {
+		return fmt.Errorf("no messages of '%s': %w", url, ErrNotFound)
+	}
+	for _, p := range posts {
+		log.Info().Str("url", url).Int64("chat_id", p.ChatId).Int("message_id", p.MessageId).Msg("deleting message")
+		err = g.tg.DeleteMessage(p.ChatId, p.MessageId)
+		if err != nil {
+			return err
+		}
+		err = g.d.DeletePost(url, p.ChatId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EditCaption replaces the caption of the messages the image url was posted as.
+func (g *Gorobei) EditCaption(url string, caption string) error {
+	posts, err := g.d.ReadPosts(url)
+	if err != nil {
+		return err
+	}
+	if len(posts) == 0 {
+		return fmt.Errorf("no messages of '%s': %w", url, ErrNotFound)
+	}
+	for _, p := range posts {
+		log.Info().Str("url", url).Int64("chat_id", p.ChatId).Int("message_id", p.MessageId).Msg("editing caption")
+		markup, err := g.currentMarkup(url, p)
+		if err != nil {
+			return err
+		}
+		err = g.tg.EditCaption(p.ChatId, p.MessageId, caption, markup)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (g *Gorobei) ForgetImg(url string) error {
-	return g.d.ReadUrlProcessed(url, 0)
+// currentMarkup rebuilds the inline keyboard of the posted message from the options of its destination
+// and the votes, the message edited without the keyboard loses its buttons.
+func (g *Gorobei) currentMarkup(url string, p *SentMessage) (*telego.InlineKeyboardMarkup, error) {
+	var source string
+	r, err := g.d.ReadImage(url)
+	switch {
+	case err == nil:
+		source = r.Source
+	case !errors.Is(err, ErrNotFound):
+		return nil, err
+	}
+	var opts *PostOptions
+	for _, d := range g.destinations(source) {
+		if d.chatId == p.ChatId {
+			opts = g.postOptions(d, source)
+			break
+		}
+	}
+	t, err := g.d.ReadTally(p.ChatId, p.MessageId)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	var markup *telego.InlineKeyboardMarkup
+	if opts != nil {
+		markup = postMarkup(opts)
+	}
+	// the destination may have been removed from the config since the post, the votes are kept anyway
+	if t != nil && (markup != nil || opts == nil) {
+		markup = withReactions(markup, t.Up, t.Down)
+	}
+	return markup, nil
 }
@@ -1,40 +1,142 @@
 package main
 
 import (
+	"fmt"
 	"github.com/mymmrac/telego"
 	"github.com/stretchr/testify/require"
 	"gorobei/clock"
+	"errors"
 	"os"
+	"path"
+	"strings"
 	"testing"
 	"time"
 )
 
-type telega struct {
-	msg string
+type (
+	telega struct {
+		msg       string
+		images    []sentImage
+		failChats map[int64]error
+		deleted   []int
+		reactions [2]int
+		answer    string
+		sentTo    []int64
+		albums    [][]string
+		failAlbum string
+		opts      *PostOptions
+		keyboard  *telego.InlineKeyboardMarkup
+		forwarded []int
+		chats     map[string]int64
+		chatInfo  []string
+	}
+	sentImage struct {
+		chatId  int64
+		image   Image
+		caption string
+	}
+)
+
+func (t *telega) SendImage(user string, userId int64, image *Image, caption string, opts *PostOptions) (*SentMessage, error) {
+	t.images = append(t.images, sentImage{userId, *image, caption})
+	t.opts = opts
+	if err, ok := t.failChats[userId]; ok {
+		return nil, err
+	}
+	return &SentMessage{ChatId: userId, MessageId: len(t.images), FileId: "file_" + path.Base(image.Path)}, nil
+}
+
+func (t *telega) SetRateLimit(userId int64, maxRpm float64, maxRps float64) {
+}
+
+func (t *telega) DeleteMessage(chatId int64, messageId int) error {
+	t.deleted = append(t.deleted, messageId)
+	return nil
+}
+
+func (t *telega) EditCaption(chatId int64, messageId int, caption string, markup *telego.InlineKeyboardMarkup) error {
+	t.msg = caption
+	t.keyboard = markup
+	return nil
 }
 
-func (t *telega) SendImage(user string, userId int64, image string, caption string) error {
+func (t *telega) SetReactions(chatId int64, messageId int, up int, down int, current *telego.InlineKeyboardMarkup) error {
+	t.reactions = [2]int{up, down}
+	return nil
+}
+
+func (t *telega) AnswerCallback(queryId string, text string) error {
+	t.answer = text
+	return nil
+}
+
+func (t *telega) Listen(stop <-chan struct{}, handler func(u *telego.Update)) error {
 	panic("implement me")
 }
 
+func (t *telega) SendKeyboard(userId int64, text string, markup *telego.InlineKeyboardMarkup) error {
+	t.msg = text
+	t.keyboard = markup
+	return nil
+}
+
+func (t *telega) ForwardMessage(userId int64, fromChatId int64, messageId int) error {
+	t.forwarded = append(t.forwarded, messageId)
+	return nil
+}
+
+func (t *telega) DownloadFile(fileId string) (string, error) {
+	p := path.Join(os.TempDir(), fileId+".jpg")
+	return p, os.WriteFile(p, []byte("content of "+fileId), 0600)
+}
+
+func (t *telega) SendAlbum(userId int64, fileIds []string) error {
+	for _, id := range fileIds {
+		if id == t.failAlbum {
+			return errors.New("album failed")
+		}
+	}
+	t.albums = append(t.albums, fileIds)
+	return nil
+}
+
 func (t *telega) SendMessageMarkdown(user string, userId int64, message string) error {
 	t.msg = message
+	t.sentTo = append(t.sentTo, userId)
+	return nil
+}
+
+func (t *telega) SendMessage(user string, userId int64, message *MessageBuilder) error {
+	if err, ok := t.failChats[userId]; ok {
+		return err
+	}
+	t.msg = message.Text()
+	t.sentTo = append(t.sentTo, userId)
 	return nil
 }
 
 func (t *telega) SendMessageText(user string, userId int64, message string) error {
-	panic("implement me")
+	t.msg = message
+	return nil
 }
 
 func (t *telega) DoUpdates() error {
 	panic("implement me")
 }
 
-func (t telega) ChatInfo(s string) (*telego.Chat, error) {
-	panic("implement me")
+func (t *telega) ChatInfo(s string) (*telego.Chat, error) {
+	t.chatInfo = append(t.chatInfo, s)
+	id, ok := t.chats[s]
+	if !ok {
+		return nil, errors.New("chat not found")
+	}
+	return &telego.Chat{ID: id}, nil
 }
 
-type fetcher struct {}
+// fetcher saves the image url as its content unless the content is set
+type fetcher struct {
+	content map[string]string
+}
 var _ HttpFetcher = (*fetcher)(nil)
 
 func (f *fetcher) FetchHtml(url string) (string, error) {
@@ -42,17 +144,20 @@ func (f *fetcher) FetchHtml(url string) (string, error) {
 }
 
 func (f *fetcher) FetchImage(url string) (string, error) {
-	panic("implement me")
+	content, ok := f.content[url]
+	if !ok {
+		content = url
+	}
+	p := path.Join(os.TempDir(), path.Base(url))
+	return p, os.WriteFile(p, []byte(content), 0600)
 }
 
 
 func openTestDb(t *testing.T) (*Db, func()) {
-	path := "./gorobei_test_db"
-	d, err := OpenDb(path)
+	d, err := OpenDb("memory://")
 	require.NoError(t, err)
 	return d, func() {
 		d.Close()
-		os.RemoveAll(path)
 	}
 }
 func newTestGorobei(t* testing.T) (*Gorobei, func()) {
@@ -63,8 +168,7 @@ func newTestGorobei(t* testing.T) (*Gorobei, func()) {
 		fetcher: &fetcher{},
 		clock: &clock.TestClock{Tm: time.Now()},
 		chat:         "test_chat",
-		admin:        "test_admin",
-		adminId: 776}
+		admins: []*Admin{{User: "test_admin", id: 776}}}
 
 	return g, closeFunc
 }
@@ -86,7 +190,7 @@ func TestGorobei_UpdateAndSendDailyReport(t *testing.T) {
 	err = g.UpdateAndSendDailyReport(21, 18, 3, "last error 2")
 	require.NoError(t, err)
 	require.NotEmpty(t, tg.msg)
-	require.Equal(t, tg.msg, g.FormatDailyReport(&DailyReport{1,1,1,20,"last error 1", time2}))
+	require.Equal(t, tg.msg, g.FormatDailyReport(&DailyReport{1,1,1,20,"last error 1", time2}).Text())
 
 	tg.msg = ""
 	time3, _ := time.Parse(time.Stamp, "Jan  1 23:15:00")
@@ -116,4 +220,494 @@ func TestGorobei_UpdateAndSendDailyReport2(t *testing.T) {
 	r, err = g.d.ReadDailyReport()
 	require.NoError(t, err)
 	require.Equal(t, &DailyReport{1,3,3,21,"last error 2",time2}, r)
-}
\ No newline at end of file
+}
+
+func TestGorobei_UpdateAndSendDailyReportFailed(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	clk := g.clock.(*clock.TestClock)
+	tg := g.tg.(*telega)
+	require.NoError(t, g.d.StoreSubscription(&Subscription{UserId: 11, Digest: true}))
+	require.NoError(t, g.d.QueueDigest(11, "http://img/1.jpg", "file_1"))
+
+	time1, _ := time.Parse(time.Stamp, "Jan  1 23:01:00")
+	clk.Tm = time1
+	require.NoError(t, g.UpdateAndSendDailyReport(20, 19, 1, "last error 1"))
+	// the digests are sent even if the report is not delivered
+	clk.Tm = time1.Add(24 * time.Hour)
+	tg.failChats = map[int64]error{776: errors.New("admin is away")}
+	require.Error(t, g.UpdateAndSendDailyReport(21, 18, 3, "last error 2"))
+	require.Equal(t, []sentImage{{11, Image{FileId: "file_1"}, ""}}, tg.images)
+}
+
+func TestGorobei_processImageFanOut(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	primary := &Destination{Chat: "@main", chatId: 1}
+	mirror := &Destination{Chat: "@mirror", chatId: 2}
+	mirror.Caption = "{{.Src}} via {{.Chat}}"
+	c := &Config{Sources: []*Source{{Url: "http://page", Destinations: []*Destination{primary, mirror}}}}
+	require.NoError(t, c.validate())
+	dests := c.Source("http://page").Destinations
+
+	tg.failChats = map[int64]error{2: errors.New("mirror is down")}
+	err := g.processImage("http://img/1.jpg", "http://page", dests)
+	require.Error(t, err)
+	require.Len(t, tg.images, 2)
+	require.Equal(t, "http://img/1.jpg via @mirror", tg.images[1].caption)
+
+	r, err := g.d.ReadImage("http://img/1.jpg")
+	require.NoError(t, err)
+	require.Equal(t, ImageFailed, r.Status)
+	require.Equal(t, "http://page", r.Source)
+	require.Equal(t, 1, r.Attempts)
+	require.Len(t, r.Posts, 1)
+	require.NotEmpty(t, r.Hash)
+	require.Contains(t, r.LastError, "mirror is down")
+
+	// only the failed destination is retried
+	tg.failChats = nil
+	tg.images = nil
+	err = g.processImage("http://img/1.jpg", "http://page", dests)
+	require.NoError(t, err)
+	// the file uploaded to the first chat is reused
+	require.Equal(t, []sentImage{{2, Image{FileId: "file_1.jpg"}, "http://img/1.jpg via @mirror"}}, tg.images)
+	r, err = g.d.ReadImage("http://img/1.jpg")
+	require.NoError(t, err)
+	require.Equal(t, ImagePosted, r.Status)
+	require.Equal(t, 2, r.Attempts)
+	require.Equal(t, []int64{1, 2}, []int64{r.Posts[0].ChatId, r.Posts[1].ChatId})
+	require.Empty(t, r.LastError)
+
+	tg.images = nil
+	err = g.processImage("http://img/1.jpg", "http://page", dests)
+	require.ErrorIs(t, err, ErrImageAlreadyProcessed)
+	require.Empty(t, tg.images)
+}
+
+func TestGorobei_processImageDuplicate(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	g.fetcher.(*fetcher).content = map[string]string{"http://img/copy.jpg": "http://img/1.jpg"}
+	g.chatId = 1
+	dests := g.destinations("http://page")
+
+	require.NoError(t, g.processImage("http://img/1.jpg", "http://page", dests))
+	err := g.processImage("http://img/copy.jpg", "http://page", dests)
+	require.ErrorIs(t, err, ErrImageAlreadyProcessed)
+	require.Len(t, tg.images, 1)
+
+	first, err := g.d.ReadImage("http://img/1.jpg")
+	require.NoError(t, err)
+	r, err := g.d.ReadImage("http://img/copy.jpg")
+	require.NoError(t, err)
+	require.Equal(t, ImageDuplicate, r.Status)
+	require.Equal(t, "http://img/1.jpg", r.DuplicateOf)
+	require.Equal(t, "http://page", r.Source)
+	require.Equal(t, first.Hash, r.Hash)
+	require.False(t, r.FirstSeen.IsZero())
+}
+
+func TestGorobei_RunDaemonInterval(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		err := g.RunDaemon(nil, interval, 0, &Maintenance{})
+		require.EqualError(t, err, fmt.Sprintf("interval must be positive, got %v", interval))
+	}
+}
+
+func TestGorobei_handleUpdateWaitsForPosting(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	// the fetch loop is posting
+	g.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		g.handleUpdate(&telego.Update{CallbackQuery: &telego.CallbackQuery{}})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("the update is handled while posting")
+	case <-time.After(50 * time.Millisecond):
+	}
+	g.mu.Unlock()
+	<-done
+}
+
+func TestGorobei_processImageChatState(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	group := &Destination{Chat: "@group", chatId: 1}
+	kicked := &Destination{Chat: "@kicked", chatId: 2}
+	dests := []*Destination{group, kicked}
+	g.chatId = 1
+
+	require.NoError(t, g.d.StoreUrlSent("http://img/0.jpg", "", 1, 1))
+	tg.failChats = map[int64]error{
+		1: &ChatMigratedError{From: 1, To: 100},
+		2: &ChatUnavailableError{ChatId: 2, Reason: "bot was kicked"},
+	}
+	err := g.processImage("http://img/1.jpg", "http://page", dests)
+	// the image hasn't reached the paused chat
+	require.ErrorIs(t, err, ErrChatsPaused)
+	r, err := g.d.ReadImage("http://img/1.jpg")
+	require.NoError(t, err)
+	require.Equal(t, ImagePending, r.Status)
+	require.Len(t, r.Posts, 1)
+	// the send is retried in the supergroup
+	require.Equal(t, []int64{1, 100, 2}, []int64{tg.images[0].chatId, tg.images[1].chatId, tg.images[2].chatId})
+	require.Equal(t, int64(100), group.chatId)
+	require.Equal(t, int64(100), g.chatId)
+	to, err := g.migratedChatId(1)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), to)
+	done, err := g.d.ReadUrlSent("http://img/0.jpg", 100)
+	require.NoError(t, err)
+	require.Equal(t, byte(1), done)
+	// the admin is alerted about the paused chat
+	require.Contains(t, tg.msg, "Posting to the chat @kicked is paused: bot was kicked")
+
+	// the paused chat is skipped
+	tg.images = nil
+	tg.msg = ""
+	err = g.processImage("http://img/2.jpg", "http://page", []*Destination{kicked})
+	require.ErrorIs(t, err, ErrChatsPaused)
+	require.Empty(t, tg.images)
+	require.Empty(t, tg.msg)
+
+	// the pending image is sent after the chat is resumed
+	tg.failChats = nil
+	require.NoError(t, g.d.DeleteChatPause(2))
+	err = g.processImage("http://img/1.jpg", "http://page", dests)
+	require.NoError(t, err)
+	require.Len(t, tg.images, 1)
+	require.Equal(t, int64(2), tg.images[0].chatId)
+	r, err = g.d.ReadImage("http://img/1.jpg")
+	require.NoError(t, err)
+	require.Equal(t, ImagePosted, r.Status)
+	require.Len(t, r.Posts, 2)
+}
+
+func TestGorobei_ForgetImgDelete(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	g.chatId = 1
+	dests := g.destinations("http://page")
+
+	require.NoError(t, g.processImage("http://img/1.jpg", "http://page", dests))
+	require.Len(t, tg.images, 1)
+	require.NoError(t, g.ForgetImg("http://img/1.jpg", true))
+	require.Equal(t, []int{1}, tg.deleted)
+	posts, err := g.d.ReadPosts("http://img/1.jpg")
+	require.NoError(t, err)
+	require.Empty(t, posts)
+	// the deleted message is not listed anymore
+	_, err = g.d.ReadMessageUrl(1, 1)
+	require.ErrorIs(t, err, ErrNotFound)
+	r, err := g.d.ReadImage("http://img/1.jpg")
+	require.NoError(t, err)
+	require.Equal(t, ImageForgotten, r.Status)
+	require.Empty(t, r.Posts)
+
+	// the unknown url is not recorded
+	require.ErrorIs(t, g.ForgetImg("http://img/unknown.jpg", false), ErrNotFound)
+	_, err = g.d.ReadImage("http://img/unknown.jpg")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, g.DeletePost("http://img/1.jpg"), ErrNotFound)
+
+	// forgotten image is posted again
+	require.NoError(t, g.processImage("http://img/1.jpg", "http://page", dests))
+	require.Len(t, tg.images, 2)
+}
+
+func TestGorobei_handleCallback(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	require.NoError(t, g.d.StorePost("http://img/1.jpg", &SentMessage{ChatId: -100, MessageId: 5}))
+	vote := func(userId int64, data string) {
+		g.handleCallback(&telego.CallbackQuery{
+			ID:      "q",
+			From:    telego.User{ID: userId},
+			Message: &telego.Message{MessageID: 5, Chat: telego.Chat{ID: -100}},
+			Data:    data,
+		})
+	}
+	vote(1, CallbackVoteUp)
+	vote(2, CallbackVoteUp)
+	vote(3, CallbackVoteDown)
+	require.Equal(t, [2]int{2, 1}, tg.reactions)
+	vote(1, CallbackVoteDown)
+	require.Equal(t, "You have voted already.", tg.answer)
+	require.Equal(t, [2]int{2, 1}, tg.reactions)
+
+	top, err := g.d.TopVoted(3)
+	require.NoError(t, err)
+	require.Equal(t, []*VoteTally{{ChatId: -100, MessageId: 5, Url: "http://img/1.jpg", Up: 2, Down: 1}}, top)
+	report := g.FormatDailyReport(&DailyReport{})
+	require.Contains(t, report.Text(), "1. image 👍 2 👎 1")
+	require.Contains(t, report.Entities(), telego.MessageEntity{Type: EntityTextLink, Offset: strings.Index(report.Text(), "image 👍"), Length: 5, URL: "http://img/1.jpg"})
+}
+func TestGorobei_Notify(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	ops := &Admin{User: "ops", MinSeverity: "warning", Kinds: []NotificationKind{NotifyFailure, NotifySummary}, id: 1}
+	boss := &Admin{User: "boss", Kinds: []NotificationKind{NotifyReport}, id: 2}
+	require.NoError(t, ops.validate())
+	require.NoError(t, boss.validate())
+	g.admins = []*Admin{ops, boss}
+
+	send := func(kind NotificationKind, severity Severity) []int64 {
+		tg.sentTo = nil
+		require.NoError(t, g.Notify(kind, severity, NewMessage().Plain("msg")))
+		return tg.sentTo
+	}
+	require.Empty(t, send(NotifySummary, SeverityInfo))
+	require.Equal(t, []int64{1}, send(NotifySummary, SeverityWarning))
+	require.Equal(t, []int64{1}, send(NotifyFailure, SeverityError))
+	require.Equal(t, []int64{2}, send(NotifyReport, SeverityInfo))
+	require.Equal(t, []int64{1, 2}, send(NotifyDirect, SeverityInfo))
+
+	require.Error(t, (&Admin{User: "x", MinSeverity: "fatal"}).validate())
+}
+
+
+func TestGorobei_Subscriptions(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	dest := &Destination{Chat: "@main", chatId: 1}
+	g.config = &Config{Sources: []*Source{{Name: "cats", Url: "http://page", Destinations: []*Destination{dest}}}}
+	command := func(userId int64, text string) {
+		g.handleCommand(&telego.Message{Chat: telego.Chat{ID: userId, Type: "private"}, Text: text})
+	}
+	command(10, "/subscribe cats")
+	command(11, "/subscribe cats digest")
+	command(12, "/subscribe dogs")
+	require.Contains(t, tg.msg, "Unknown source 'dogs'")
+
+	require.NoError(t, g.processImage("http://img/1.jpg", "http://page", []*Destination{dest}))
+	// the channel post and the immediate subscriber
+	require.Len(t, tg.images, 2)
+	require.Equal(t, sentImage{10, Image{FileId: "file_1.jpg"}, ""}, tg.images[1])
+
+	// the single image is sent as a photo
+	tg.images = nil
+	g.SendDigests()
+	require.Empty(t, tg.albums)
+	require.Equal(t, []sentImage{{11, Image{FileId: "file_1.jpg"}, ""}}, tg.images)
+	// the digest is cleared after sending
+	g.SendDigests()
+	require.Len(t, tg.images, 1)
+
+	// the album sent before the failed one is not sent again
+	for i := 1; i <= 11; i++ {
+		require.NoError(t, g.d.QueueDigest(11, fmt.Sprintf("http://img/%02d.jpg", i), fmt.Sprintf("file_%02d", i)))
+	}
+	tg.failAlbum = "file_07"
+	g.SendDigests()
+	require.Equal(t, [][]string{{"file_01", "file_02", "file_03", "file_04", "file_05", "file_06"}}, tg.albums)
+	files, err := g.d.ReadDigest(11)
+	require.NoError(t, err)
+	require.Equal(t, []string{"file_07", "file_08", "file_09", "file_10", "file_11"}, files)
+	tg.failAlbum = ""
+	g.SendDigests()
+	require.Equal(t, []string{"file_07", "file_08", "file_09", "file_10", "file_11"}, tg.albums[1])
+
+	command(10, "/unsubscribe")
+	subs, err := g.d.ReadSubscriptions("http://page")
+	require.NoError(t, err)
+	require.Equal(t, []*Subscription{{UserId: 11, Source: "http://page", Digest: true}}, subs)
+}
+func TestGorobei_postOptions(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	clk := g.clock.(*clock.TestClock)
+	tg := g.tg.(*telega)
+	src := &Source{Url: "http://page",
+		Options: &PostOptions{ThreadId: 7, SourceButton: "Source", QuietHours: &QuietHours{From: "23:00", To: "08:00"}},
+		Destinations: []*Destination{{Chat: "@forum", chatId: 1}}}
+	require.NoError(t, (&Config{Sources: []*Source{src}}).validate())
+
+	clk.Tm = time.Date(2022, 1, 1, 12, 0, 0, 0, time.Local)
+	require.NoError(t, g.processImage("http://img/1.jpg", "http://page", src.Destinations))
+	require.Equal(t, 7, tg.opts.ThreadId)
+	require.Equal(t, "http://page", tg.opts.sourceUrl)
+	require.False(t, tg.opts.DisableNotification)
+
+	clk.Tm = time.Date(2022, 1, 1, 2, 30, 0, 0, time.Local)
+	require.NoError(t, g.processImage("http://img/2.jpg", "http://page", src.Destinations))
+	require.True(t, tg.opts.DisableNotification)
+	// destination options are not modified
+	require.False(t, src.Destinations[0].Options.DisableNotification)
+}
+
+func TestGorobei_Submissions(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	fc := g.fetcher.(*fetcher)
+	g.chat, g.chatId = "@main", 1
+	admin := &telego.User{ID: 776}
+	dm := telego.Chat{ID: 776, Type: "private"}
+
+	// not an admin
+	require.False(t, g.handleSubmission(&telego.Message{Chat: telego.Chat{ID: 5, Type: "private"}, From: &telego.User{ID: 5}, Text: "http://img/1.jpg"}))
+	// no image
+	require.False(t, g.handleSubmission(&telego.Message{Chat: dm, From: admin, Text: "/subscribe"}))
+
+	m := &telego.Message{MessageID: 10, Chat: dm, From: admin, Caption: "found it",
+		Photo: []telego.PhotoSize{{FileID: "small", FileUniqueID: "u1"}, {FileID: "large", FileUniqueID: "u2"}}}
+	require.True(t, g.handleSubmission(m))
+	require.Equal(t, "Where to post the image?", tg.msg)
+	require.Equal(t, "post:10:1", tg.keyboard.InlineKeyboard[0][0].CallbackData)
+
+	q := &telego.CallbackQuery{ID: "q1", From: *admin, Data: "post:10:1"}
+	g.handleCallback(&telego.CallbackQuery{ID: "q1", From: *admin, Data: "post:10:1", Message: &telego.Message{}})
+	require.Equal(t, "Posted to @main.", tg.answer)
+	require.Equal(t, []sentImage{{1, Image{Path: path.Join(os.TempDir(), "large.jpg"), FileId: "large"}, "found it"}}, tg.images)
+
+	tg.images = nil
+	require.Equal(t, "The image has been posted to @main already.", g.postSubmission(q))
+	require.Empty(t, tg.images)
+
+	// the url of the image having the same content
+	fc.content = map[string]string{"http://img/copy.jpg": "content of large"}
+	m = &telego.Message{MessageID: 11, Chat: dm, From: admin, Text: "http://img/copy.jpg"}
+	require.True(t, g.handleSubmission(m))
+	q.Data = "post:11:1"
+	require.Equal(t, "The image has been posted to @main already.", g.postSubmission(q))
+	require.Empty(t, tg.images)
+
+	// the document is uploaded as a photo
+	m = &telego.Message{MessageID: 12, Chat: dm, From: admin,
+		Document: &telego.Document{FileID: "doc", FileUniqueID: "u3", MimeType: "image/png"}}
+	require.True(t, g.handleSubmission(m))
+	q.Data = "post:12:1"
+	require.Equal(t, "Posted to @main.", g.postSubmission(q))
+	require.Equal(t, []sentImage{{1, Image{Path: path.Join(os.TempDir(), "doc.jpg")}, ""}}, tg.images)
+}
+
+func TestGorobei_Suggestions(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	g.chat, g.chatId = "@main", 1
+	g.config = &Config{Suggestions: &Suggestions{DailyQuota: 2, Credit: true}}
+	admin := telego.User{ID: 776}
+	user := &telego.User{ID: 42, Username: "Fan"}
+	dm := telego.Chat{ID: 42, Type: "private"}
+	photo := func(id int) *telego.Message {
+		return &telego.Message{MessageID: id, Chat: dm, From: user, Caption: "look",
+			Photo: []telego.PhotoSize{{FileID: fmt.Sprintf("f%v", id), FileUniqueID: fmt.Sprintf("u%v", id)}}}
+	}
+
+	// unknown user
+	require.True(t, g.handleSuggestion(photo(1)))
+	require.Contains(t, tg.msg, "only registered users")
+	require.Empty(t, tg.forwarded)
+
+	require.NoError(t, g.d.StoreUserId("fan", 42))
+	require.True(t, g.handleSuggestion(photo(2)))
+	require.Equal(t, "Thank you! The image will be posted after review.", tg.msg)
+	require.Equal(t, []int{2}, tg.forwarded)
+	require.Equal(t, "Post to @main", tg.keyboard.InlineKeyboard[0][0].Text)
+	require.Equal(t, "approve:42:2:1", tg.keyboard.InlineKeyboard[0][0].CallbackData)
+	require.Equal(t, "reject:42:2", tg.keyboard.InlineKeyboard[1][0].CallbackData)
+
+	require.True(t, g.handleSuggestion(photo(3)))
+	// the quota is exceeded
+	require.True(t, g.handleSuggestion(photo(4)))
+	require.Contains(t, tg.msg, "You can suggest 2 images per day")
+	queue, err := g.d.ReadSuggestions()
+	require.NoError(t, err)
+	require.Len(t, queue, 2)
+
+	q := &telego.CallbackQuery{ID: "q", From: admin, Data: "approve:42:2:1"}
+	require.Equal(t, "Only admins can review suggestions.", g.review(&telego.CallbackQuery{From: *user, Data: q.Data}))
+	require.Equal(t, "Posted to @main.", g.review(q))
+	require.Equal(t, "Thank you! The image you suggested has been posted to @main.", tg.msg)
+	require.Len(t, tg.images, 1)
+	require.Equal(t, "look\n\nSuggested by @Fan", tg.images[0].caption)
+	require.Equal(t, "The suggestion has been reviewed already.", g.review(q))
+
+	q.Data = "reject:42:3"
+	require.Equal(t, "Rejected.", g.review(q))
+	require.Equal(t, "Sorry, the image you suggested has been declined.", tg.msg)
+	queue, err = g.d.ReadSuggestions()
+	require.NoError(t, err)
+	require.Empty(t, queue)
+}
+
+func TestGorobei_EditCaption(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	src := &Source{Url: "http://page", Options: &PostOptions{Reactions: true, SourceButton: "Source"},
+		Destinations: []*Destination{{Chat: "@posts", chatId: 1}}}
+	require.NoError(t, (&Config{Sources: []*Source{src}}).validate())
+	g.config = &Config{Sources: []*Source{src}}
+
+	require.NoError(t, g.processImage("http://img/1.jpg", "http://page", src.Destinations))
+	_, err := g.d.StoreVote(1, 1, 100, true)
+	require.NoError(t, err)
+
+	require.NoError(t, g.EditCaption("http://img/1.jpg", "new caption"))
+	require.Equal(t, "new caption", tg.msg)
+	// the buttons are kept
+	require.Equal(t, &telego.InlineKeyboardMarkup{InlineKeyboard: [][]telego.InlineKeyboardButton{
+		{{Text: "👍 1", CallbackData: CallbackVoteUp}, {Text: "👎 0", CallbackData: CallbackVoteDown}},
+		{{Text: "Source", URL: "http://page"}},
+	}}, tg.keyboard)
+
+	require.ErrorIs(t, g.EditCaption("http://img/2.jpg", "caption"), ErrNotFound)
+}
+
+func TestGorobei_resolveChat(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	tg := g.tg.(*telega)
+	tg.chats = map[string]int64{"@posts": -1001}
+
+	id, err := g.resolveChat("-1002")
+	require.NoError(t, err)
+	require.Equal(t, int64(-1002), id)
+	require.Empty(t, tg.chatInfo)
+
+	for _, chat := range []string{"@Posts", "https://t.me/posts", "t.me/posts/15"} {
+		id, err = g.resolveChat(chat)
+		require.NoError(t, err)
+		require.Equal(t, int64(-1001), id)
+	}
+	// the resolved ID is cached
+	require.Equal(t, []string{"@posts"}, tg.chatInfo)
+
+	require.NoError(t, g.d.StoreUserId("john", 42))
+	id, err = g.resolveChat("John")
+	require.NoError(t, err)
+	require.Equal(t, int64(42), id)
+
+	_, err = g.resolveChat("@unknown")
+	require.Error(t, err)
+
+	// offline commands resolve the cached names only
+	g.tg = nil
+	id, err = g.resolveChat("@posts")
+	require.NoError(t, err)
+	require.Equal(t, int64(-1001), id)
+	_, err = g.resolveChat("@other")
+	require.ErrorIs(t, err, ErrNotFound)
+	g.tg = tg
+
+	g.admins = []*Admin{{User: "t.me/john"}, {User: "777"}}
+	require.NoError(t, g.initAdmins())
+	require.Equal(t, int64(42), g.admins[0].id)
+	require.Equal(t, int64(777), g.admins[1].id)
+}
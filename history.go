@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type (
+	// HistoryFilter selects the image records, zero fields match everything.
+	HistoryFilter struct {
+		Url    string
+		Source string
+		// From and To are the range of the first seen time, To is exclusive
+		From   time.Time
+		To     time.Time
+		Status ImageStatus
+		ChatId int64
+	}
+
+	// historyEntry is the image record as it is printed
+	historyEntry struct {
+		Url       string      `json:"url"`
+		FirstSeen *time.Time  `json:"first_seen,omitempty"`
+		PostedAt  *time.Time  `json:"posted_at,omitempty"`
+		Source    string      `json:"source,omitempty"`
+		Status    ImageStatus `json:"status"`
+		Attempts  int         `json:"attempts"`
+		Hash      string      `json:"hash,omitempty"`
+		LastError string      `json:"last_error,omitempty"`
+		// DuplicateOf is the url the same content was posted under
+		DuplicateOf string       `json:"duplicate_of,omitempty"`
+		Posts       []*ImagePost `json:"posts,omitempty"`
+	}
+)
+
+const (
+	HistoryTable = "table"
+	HistoryJson  = "json"
+	HistoryCsv   = "csv"
+)
+
+// ParseHistoryDate parses the date (2006-01-02) or the time (RFC 3339). The date is the local midnight,
+// endOfDay moves it to the next midnight, so the date is included into the range.
+func ParseHistoryDate(s string, endOfDay bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s', expected 2006-01-02 or RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+// Match checks if the record satisfies the filter.
+func (f *HistoryFilter) Match(r *ImageRecord) bool {
+	if f.Url != "" && r.Url != f.Url {
+		return false
+	}
+	if f.Source != "" && r.Source != f.Source {
+		return false
+	}
+	if f.Status != "" && r.Status != f.Status {
+		return false
+	}
+	if f.ChatId != 0 {
+		for _, p := range r.Posts {
+			if p.ChatId == f.ChatId {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// History writes the image records matching the filter in the order they were first seen.
+func (g *Gorobei) History(filter *HistoryFilter, format string, w io.Writer) error {
+	if filter.Source != "" {
+		if src := g.config.SourceByName(filter.Source); src != nil {
+			filter.Source = src.Url
+		}
+	}
+	var records []*ImageRecord
+	if filter.Url != "" {
+		// no need to scan
+		r, err := g.d.ReadImage(filter.Url)
+		if err != nil {
+			return err
+		}
+		inRange := !r.FirstSeen.Before(filter.From) && (filter.To.IsZero() || r.FirstSeen.Before(filter.To))
+		if inRange && filter.Match(r) {
+			records = append(records, r)
+		}
+	} else {
+		err := g.d.ReadImages(filter.From, filter.To, func(r *ImageRecord) error {
+			if filter.Match(r) {
+				records = append(records, r)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return writeHistory(records, format, w)
+}
+
+func newHistoryEntry(r *ImageRecord) *historyEntry {
+	e := &historyEntry{
+		Url:         r.Url,
+		Source:      r.Source,
+		Status:      r.Status,
+		Attempts:    r.Attempts,
+		Hash:        r.Hash,
+		LastError:   r.LastError,
+		Posts:       r.Posts,
+		DuplicateOf: r.DuplicateOf,
+	}
+	if !r.FirstSeen.IsZero() {
+		e.FirstSeen = &r.FirstSeen
+	}
+	if !r.PostedAt.IsZero() {
+		e.PostedAt = &r.PostedAt
+	}
+	return e
+}
+
+func formatHistoryTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// posts formats the messages as 'chat_id/message_id' list.
+func (e *historyEntry) posts() string {
+	var res []string
+	for _, p := range e.Posts {
+		res = append(res, fmt.Sprintf("%v/%v", p.ChatId, p.MessageId))
+	}
+	return strings.Join(res, " ")
+}
+
+func writeHistory(records []*ImageRecord, format string, w io.Writer) error {
+	switch format {
+	case HistoryJson:
+		entries := make([]*historyEntry, 0, len(records))
+		for _, r := range records {
+			entries = append(entries, newHistoryEntry(r))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case HistoryCsv:
+		cw := csv.NewWriter(w)
+		err := cw.Write([]string{"first_seen", "posted_at", "status", "attempts", "source", "url", "posts", "hash", "last_error"})
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			e := newHistoryEntry(r)
+			var seen, posted string
+			if e.FirstSeen != nil {
+				seen = e.FirstSeen.Format(time.RFC3339)
+			}
+			if e.PostedAt != nil {
+				posted = e.PostedAt.Format(time.RFC3339)
+			}
+			err = cw.Write([]string{seen, posted, string(e.Status), strconv.Itoa(e.Attempts), e.Source, e.Url,
+				e.posts(), e.Hash, e.LastError})
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case HistoryTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FIRST SEEN\tPOSTED AT\tSTATUS\tATTEMPTS\tURL\tPOSTS\tLAST ERROR")
+		for _, r := range records {
+			e := newHistoryEntry(r)
+			// the error may be multiline
+			lastError := strings.ReplaceAll(e.LastError, "\n", " ")
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%s\t%s\t%s\n", formatHistoryTime(e.FirstSeen), formatHistoryTime(e.PostedAt),
+				e.Status, e.Attempts, e.Url, e.posts(), lastError)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown history format: '%s'", format)
+}
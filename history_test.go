@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGorobei_History(t *testing.T) {
+	g, f := newTestGorobei(t)
+	defer f()
+	g.config = &Config{Sources: []*Source{{Name: "main", Url: "http://page"}}}
+	day := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	records := []*ImageRecord{
+		{Url: "http://img/3.jpg", FirstSeen: day.Add(48 * time.Hour), Source: "http://page", Status: ImageFailed,
+			Attempts: 2, LastError: "cannot send\nimage"},
+		{Url: "http://img/1.jpg", FirstSeen: day, PostedAt: day, Source: "http://page", Status: ImagePosted, Attempts: 1,
+			Posts: []*ImagePost{{ChatId: 1, MessageId: 10, PostedAt: day}, {ChatId: 2, MessageId: 20, PostedAt: day}}},
+		{Url: "http://img/2.jpg", FirstSeen: day.Add(24 * time.Hour), Source: "http://other", Status: ImagePosted,
+			Attempts: 1, Posts: []*ImagePost{{ChatId: 2, MessageId: 21}}},
+		{Url: "http://img/0.jpg", Status: ImageArchived},
+	}
+	for _, r := range records {
+		require.NoError(t, g.d.StoreImage(r))
+	}
+	// first seen time is changed, the record moves in the index
+	require.NoError(t, g.d.UpdateImage("http://img/0.jpg", func(r *ImageRecord) {
+		r.FirstSeen = day.Add(-time.Hour)
+	}))
+
+	urls := func(filter *HistoryFilter) []string {
+		var buf bytes.Buffer
+		require.NoError(t, g.History(filter, HistoryJson, &buf))
+		var entries []*historyEntry
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entries))
+		res := []string{}
+		for _, e := range entries {
+			res = append(res, e.Url)
+		}
+		return res
+	}
+	require.Equal(t, []string{"http://img/0.jpg", "http://img/1.jpg", "http://img/2.jpg", "http://img/3.jpg"}, urls(&HistoryFilter{}))
+	require.Equal(t, []string{"http://img/1.jpg", "http://img/3.jpg"}, urls(&HistoryFilter{Source: "main"}))
+	require.Equal(t, []string{"http://img/1.jpg", "http://img/2.jpg"}, urls(&HistoryFilter{Status: ImagePosted}))
+	require.Equal(t, []string{"http://img/1.jpg", "http://img/2.jpg"}, urls(&HistoryFilter{ChatId: 2}))
+	require.Equal(t, []string{"http://img/1.jpg", "http://img/2.jpg"}, urls(&HistoryFilter{From: day, To: day.Add(48 * time.Hour)}))
+	require.Equal(t, []string{"http://img/2.jpg"}, urls(&HistoryFilter{Url: "http://img/2.jpg"}))
+	require.Equal(t, []string{}, urls(&HistoryFilter{Url: "http://img/2.jpg", Status: ImageFailed}))
+
+	var buf bytes.Buffer
+	require.NoError(t, g.History(&HistoryFilter{Status: ImageFailed}, HistoryCsv, &buf))
+	require.Equal(t, "first_seen,posted_at,status,attempts,source,url,posts,hash,last_error\n"+
+		"2022-05-03T10:00:00Z,,failed,2,http://page,http://img/3.jpg,,,\"cannot send\nimage\"\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, g.History(&HistoryFilter{Source: "http://page"}, HistoryTable, &buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	require.True(t, strings.HasPrefix(lines[0], "FIRST SEEN"))
+	require.Contains(t, lines[1], "1/10 2/20")
+	require.Contains(t, lines[2], "cannot send image")
+
+	require.Error(t, g.History(&HistoryFilter{}, "xml", &buf))
+}
+
+func TestParseHistoryDate(t *testing.T) {
+	from, err := ParseHistoryDate("2022-05-01", false)
+	require.NoError(t, err)
+	to, err := ParseHistoryDate("2022-05-01", true)
+	require.NoError(t, err)
+	require.Equal(t, 24*time.Hour, to.Sub(from))
+	tm, err := ParseHistoryDate("2022-05-01T10:00:00Z", true)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC), tm.UTC())
+	_, err = ParseHistoryDate("yesterday", false)
+	require.Error(t, err)
+}
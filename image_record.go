@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type (
+	ImageStatus string
+
+	// ImageRecord is the processing history of the image url.
+	ImageRecord struct {
+		Url       string      `json:"-"`
+		FirstSeen time.Time   `json:"first_seen,omitempty"`
+		PostedAt  time.Time   `json:"posted_at,omitempty"`
+		Source    string      `json:"source,omitempty"`
+		Hash      string      `json:"hash,omitempty"`
+		Status    ImageStatus `json:"status"`
+		Attempts  int         `json:"attempts,omitempty"`
+		LastError string      `json:"last_error,omitempty"`
+		// DuplicateOf is the url the same content was posted under
+		DuplicateOf string `json:"duplicate_of,omitempty"`
+		// Posts are the messages the image was posted as
+		Posts []*ImagePost `json:"posts,omitempty"`
+	}
+
+	// ImagePost is the message of the destination chat.
+	ImagePost struct {
+		ChatId    int64     `json:"chat_id"`
+		MessageId int       `json:"message_id"`
+		PostedAt  time.Time `json:"posted_at"`
+	}
+)
+
+const (
+	// ImagePending is the image which hasn't been sent yet
+	ImagePending ImageStatus = "pending"
+	// ImagePosted is the image sent to all the destinations
+	ImagePosted ImageStatus = "posted"
+	// ImageFailed is the image which couldn't be sent to some of the destinations on the last attempt
+	ImageFailed ImageStatus = "failed"
+	// ImageForgotten is the image processed as new next time
+	ImageForgotten ImageStatus = "forgotten"
+	// ImageArchived is the image posted before the destinations were tracked, it is never sent again
+	ImageArchived ImageStatus = "archived"
+	// ImageDuplicate is the image not sent as its content has been posted under another url
+	ImageDuplicate ImageStatus = "duplicate"
+)
+
+// imageRecordV1 is the first byte of the encoded record, the rest is json
+const imageRecordV1 = 1
+
+func encodeImageRecord(r *ImageRecord) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{imageRecordV1}, data...), nil
+}
+
+func decodeImageRecord(url string, v []byte) (*ImageRecord, error) {
+	if len(v) < 2 {
+		return nil, fmt.Errorf("image record of '%s' is too short: %v", url, v)
+	}
+	r := &ImageRecord{Url: url}
+	switch v[0] {
+	case imageRecordV1:
+		err := json.Unmarshal(v[1:], r)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode image record of '%s': %w", url, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported image record version %v of '%s'", v[0], url)
+	}
+	return r, nil
+}
+
+func readImage(txn kvTxn, key []byte, url string) (*ImageRecord, error) {
+	v, err := txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImageRecord(url, v)
+}
+
+// ReadImage returns the record of the image url or ErrNotFound if the url has never been processed.
+func (d *Db) ReadImage(url string) (*ImageRecord, error) {
+	var r *ImageRecord
+	err := d.b.View(func(txn kvTxn) error {
+		var err error
+		r, err = readImage(txn, d.constructUrlKey(url), url)
+		return err
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	return r, err
+}
+
+// constructSeenKey is the key of the index ordering the images by the time they were first seen.
+func (d *Db) constructSeenKey(seen time.Time, url string) []byte {
+	return []byte(nsSeen + seenTimestamp(seen) + "_" + url)
+}
+
+func seenTimestamp(t time.Time) string {
+	var ns int64
+	if !t.IsZero() {
+		ns = t.UnixNano()
+	}
+	return fmt.Sprintf("%020d", ns)
+}
+
+// setImage stores the record and updates the index, old is the record being replaced if any.
+func (d *Db) setImage(txn kvTxn, old *ImageRecord, r *ImageRecord) error {
+	data, err := encodeImageRecord(r)
+	if err != nil {
+		return err
+	}
+	// the index key and the record expire at the same time
+	expiresAt := d.expiresAt(d.retentionOf(r.Source).Urls)
+	if old != nil && !old.FirstSeen.Equal(r.FirstSeen) {
+		err = txn.Delete(d.constructSeenKey(old.FirstSeen, old.Url))
+		if err != nil {
+			return err
+		}
+	}
+	err = txn.SetEntry(d.constructSeenKey(r.FirstSeen, r.Url), nil, expiresAt)
+	if err != nil {
+		return err
+	}
+	return txn.SetEntry(d.constructUrlKey(r.Url), data, expiresAt)
+}
+
+// StoreImage replaces the record of the image url.
+func (d *Db) StoreImage(r *ImageRecord) error {
+	return d.b.Update(func(txn kvTxn) error {
+		old, err := readImage(txn, d.constructUrlKey(r.Url), r.Url)
+		if err != nil && !errors.Is(err, errKeyNotFound) {
+			return err
+		}
+		return d.setImage(txn, old, r)
+	})
+}
+
+// ReadImages calls f for the images first seen in [from, to) range in the order they were seen.
+// Zero to means no upper bound. The images seen before the records were introduced have zero FirstSeen.
+func (d *Db) ReadImages(from time.Time, to time.Time, f func(r *ImageRecord) error) error {
+	prefix := []byte(nsSeen)
+	start := []byte(nsSeen + seenTimestamp(from))
+	var end []byte
+	if !to.IsZero() {
+		end = []byte(nsSeen + seenTimestamp(to))
+	}
+	return d.b.View(func(txn kvTxn) error {
+		return txn.Scan(prefix, start, func(key []byte, value []byte, expiresAt uint64) error {
+			if end != nil && bytes.Compare(key, end) >= 0 {
+				return errStopScan
+			}
+			// the key is 'seen_<timestamp>_<url>'
+			url := string(key[len(start)+1:])
+			r, err := readImage(txn, d.constructUrlKey(url), url)
+			if errors.Is(err, errKeyNotFound) {
+				// the record has expired
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return f(r)
+		})
+	})
+}
+
+// UpdateImage modifies the record of the image url, the pending record is created if there is none.
+func (d *Db) UpdateImage(url string, f func(r *ImageRecord)) error {
+	key := d.constructUrlKey(url)
+	return d.b.Update(func(txn kvTxn) error {
+		old, err := readImage(txn, key, url)
+		r := &ImageRecord{Url: url, Status: ImagePending}
+		switch {
+		case err == nil:
+			copied := *old
+			r = &copied
+		case !errors.Is(err, errKeyNotFound):
+			return err
+		}
+		f(r)
+		return d.setImage(txn, old, r)
+	})
+}
+
+// migrateStatusBytes converts the single byte statuses of the urls to the image records:
+// 1 is the image posted before the destinations were tracked, 0 is the forgotten one.
+func migrateStatusBytes(d *Db) error {
+	archived := append([]byte{1}, `{"status":"archived"}`...)
+	forgotten := append([]byte{1}, `{"status":"forgotten"}`...)
+	var keys, values [][]byte
+	err := d.b.View(func(txn kvTxn) error {
+		return txn.Scan([]byte("url_"), nil, func(key []byte, value []byte, expiresAt uint64) error {
+			if len(value) != 1 {
+				return nil
+			}
+			r := forgotten
+			if value[0] == 1 {
+				r = archived
+			}
+			keys = append(keys, key)
+			values = append(values, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return d.batch(len(keys), func(txn kvTxn, i int) error {
+		return txn.Set(keys[i], values[i])
+	})
+}
+
+// indexImages adds the existing image records to the first seen index.
+func indexImages(d *Db) error {
+	prefix := []byte("url_")
+	var keys [][]byte
+	err := d.b.View(func(txn kvTxn) error {
+		return txn.Scan(prefix, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			url := string(key[len(prefix):])
+			r, err := decodeImageRecord(url, value)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, []byte("seen_"+seenTimestamp(r.FirstSeen)+"_"+url))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return d.batch(len(keys), func(txn kvTxn, i int) error {
+		return txn.Set(keys[i], nil)
+	})
+}
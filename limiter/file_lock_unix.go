@@ -0,0 +1,13 @@
+//go:build !windows
+
+package limiter
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile waits for the exclusive lock of the file, it is released when the file is closed.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
@@ -0,0 +1,11 @@
+package limiter
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockFile waits for the exclusive lock of the file, it is released when the file is closed.
+func lockFile(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{})
+}
@@ -0,0 +1,59 @@
+package limiter
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// FileStore keeps the limiter states in the json file. The file is locked while the state is updated,
+// so the limiters of the processes running in parallel share the same budget.
+type FileStore struct {
+	path string
+}
+
+var _ StateStore = (*FileStore)(nil)
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (fs *FileStore) UpdateLimiterState(key string, f func(s *State)) error {
+	file, err := os.OpenFile(fs.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	// closing the file releases the lock
+	defer file.Close()
+	err = lockFile(file)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	states := make(map[string]*State)
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &states)
+		if err != nil {
+			return err
+		}
+	}
+	s := states[key]
+	if s == nil {
+		s = &State{}
+	}
+	f(s)
+	states[key] = s
+	data, err = json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, 0)
+	return err
+}
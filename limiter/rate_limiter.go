@@ -1,7 +1,9 @@
 package limiter
 
 import (
+	"github.com/phuslu/log"
 	"math"
+	"sync"
 	"time"
 	"gorobei/clock"
 )
@@ -12,12 +14,29 @@ import (
 //  - 20 messages per minute
 type (
 	RateLimiter struct {
+		mu        sync.Mutex
 		maxRpm    float64
 		maxRps    float64
 		calls     int
 		firstCall time.Time
 		delayFunc func(calls float64) float64
 		clock     clock.Clock
+		store     StateStore
+		key       string
+	}
+
+	// State is the call window of the limiter which is shared between processes
+	State struct {
+		Calls     int
+		FirstCall time.Time
+		// NextCall is the earliest time the next call of any process is allowed at
+		NextCall time.Time
+	}
+
+	// StateStore persists limiter states. UpdateLimiterState must read the state stored under the key,
+	// pass it to f and store the modified state atomically.
+	StateStore interface {
+		UpdateLimiterState(key string, f func(s *State)) error
 	}
 )
 
@@ -27,9 +46,28 @@ const (
 )
 
 func NewLimiter() *RateLimiter {
+	return NewLimiterWithRates(MaxRatePerMinute, MaxRatePerSecond)
+}
+
+// NewLimiterWithRates creates limiter with custom limits. Zero values are replaced with defaults.
+func NewLimiterWithRates(maxRpm float64, maxRps float64) *RateLimiter {
+	if maxRpm <= 0 {
+		maxRpm = MaxRatePerMinute
+	}
+	if maxRpm < 2 {
+		// delayFunc is undefined for a single call per minute
+		maxRpm = 2
+	}
+	if maxRps <= 0 {
+		maxRps = MaxRatePerSecond
+	}
+	if maxRpm/maxRps > 60 {
+		// messages per minute cannot exceed the number of seconds in a minute multiplied by rps
+		maxRpm = 60 * maxRps
+	}
 	l := &RateLimiter{
-		maxRpm: MaxRatePerMinute,
-		maxRps: MaxRatePerSecond,
+		maxRpm: maxRpm,
+		maxRps: maxRps,
 		clock:  &clock.RealClock{},
 	}
 	// https://www.wolframalpha.com/input/?i=plot+e%5E%28x%2F%2819%2F5%29%29-1+from+x%3D0+to+19
@@ -61,8 +99,49 @@ func (r *RateLimiter) delayByCallNo(call int) float64 {
 	return 1.0/r.maxRps + (cur-prev)/r.delayFunc(r.maxRpm-1)*(60-r.maxRpm/r.maxRps)
 }
 
+// Persist makes limiter keep its call window in the store under the key.
+func (r *RateLimiter) Persist(store StateStore, key string) *RateLimiter {
+	r.store = store
+	r.key = key
+	return r
+}
+
 func (r *RateLimiter) nexDelay() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.store == nil {
+		return r.localDelay()
+	}
+	var delay time.Duration
+	err := r.store.UpdateLimiterState(r.key, func(s *State) {
+		delay = r.reserve(s)
+	})
+	if err != nil {
+		// the in-memory window is still respected
+		log.Error().Err(err).Str("key", r.key).Msg("cannot update limiter state")
+		return r.localDelay()
+	}
+	return delay
+}
+
+// reserve books the call at the next allowed time of the shared state and returns the time left till the call.
+// The calls of all the processes are spaced as the calls of a single limiter.
+func (r *RateLimiter) reserve(s *State) time.Duration {
+	now := r.clock.Now()
+	at := now
+	if s.NextCall.After(at) {
+		at = s.NextCall
+	}
+	if at.Sub(s.FirstCall) >= 1*time.Minute {
+		s.FirstCall = at
+		s.Calls = 0
+	}
+	s.NextCall = at.Add(time.Duration(r.delayByCallNo(s.Calls) * float64(time.Second)))
+	s.Calls += 1
+	return at.Sub(now)
+}
 
+func (r *RateLimiter) localDelay() time.Duration {
 	now := r.clock.Now()
 	elapsed := now.Sub(r.firstCall)
 	if elapsed >= 1*time.Minute {
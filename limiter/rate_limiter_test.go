@@ -1,7 +1,10 @@
 package limiter
 
 import (
+	"github.com/stretchr/testify/require"
 	"gorobei/clock"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -49,3 +52,70 @@ func Test_TikTak(t *testing.T) {
 		rl.TikTak()
 	}
 }
+
+type memStore map[string]State
+
+func (m memStore) UpdateLimiterState(key string, f func(s *State)) error {
+	s := m[key]
+	f(&s)
+	m[key] = s
+	return nil
+}
+
+func Test_persistedDelay(t *testing.T) {
+	store := memStore{}
+	tc := &clock.TestClock{Tm: time.Now()}
+	rl1 := NewLimiter()
+	rl1.clock = tc
+	rl1.Persist(store, "chat").Init()
+	rl2 := NewLimiter()
+	rl2.clock = tc
+	rl2.Persist(store, "chat").Init()
+
+	// the limiters of the parallel processes book the calls one after another
+	var wait time.Duration
+	for i := 0; i < 6; i++ {
+		rl := rl1
+		if i%2 == 1 {
+			rl = rl2
+		}
+		require.Equal(t, wait, rl.nexDelay())
+		wait += time.Duration(rl.delayByCallNo(i) * float64(time.Second))
+	}
+	require.Equal(t, 6, store["chat"].Calls)
+
+	// the window is over, the call is not delayed
+	tc.Add(2 * time.Minute)
+	require.Equal(t, time.Duration(0), rl2.nexDelay())
+	require.Equal(t, 1, store["chat"].Calls)
+}
+
+func Test_FileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	tc := &clock.TestClock{Tm: time.Now()}
+	rl1 := NewLimiter()
+	rl1.clock = tc
+	rl1.Persist(NewFileStore(path), "chat").Init()
+	rl2 := NewLimiter()
+	rl2.clock = tc
+	rl2.Persist(NewFileStore(path), "chat").Init()
+
+	require.Equal(t, time.Duration(0), rl1.nexDelay())
+	require.Equal(t, time.Duration(rl2.delayByCallNo(0)*float64(time.Second)), rl2.nexDelay())
+
+	// the parallel updates are serialized by the file lock
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, NewFileStore(path).UpdateLimiterState("counter", func(s *State) {
+				s.Calls++
+			}))
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, NewFileStore(path).UpdateLimiterState("counter", func(s *State) {
+		require.Equal(t, 10, s.Calls)
+	}))
+}
@@ -0,0 +1,53 @@
+package limiter
+
+import (
+	"gorobei/clock"
+	"sync"
+	"time"
+)
+
+// WindowLimiter allows at most maxCalls calls within any window of the given duration.
+// It implements the bot-wide telegram limit of 30 messages per second.
+type WindowLimiter struct {
+	mu       sync.Mutex
+	maxCalls int
+	window   time.Duration
+	calls    []time.Time
+	clock    clock.Clock
+}
+
+const MaxBotRatePerSecond = 30
+
+func NewWindowLimiter(maxCalls int, window time.Duration) *WindowLimiter {
+	return &WindowLimiter{
+		maxCalls: maxCalls,
+		window:   window,
+		clock:    &clock.RealClock{},
+	}
+}
+
+// nextDelay registers the call and returns the time to wait before it can be made.
+func (w *WindowLimiter) nextDelay() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.clock.Now()
+	// drop the calls which are out of the window
+	i := 0
+	for i < len(w.calls) && now.Sub(w.calls[i]) >= w.window {
+		i++
+	}
+	w.calls = w.calls[i:]
+
+	at := now
+	if len(w.calls) >= w.maxCalls {
+		// wait until the oldest call of the last maxCalls leaves the window
+		at = w.calls[len(w.calls)-w.maxCalls].Add(w.window)
+	}
+	w.calls = append(w.calls, at)
+	return at.Sub(now)
+}
+
+func (w *WindowLimiter) TikTak() {
+	<-time.After(w.nextDelay())
+}
@@ -0,0 +1,26 @@
+package limiter
+
+import (
+	"github.com/stretchr/testify/require"
+	"gorobei/clock"
+	"testing"
+	"time"
+)
+
+func Test_windowNextDelay(t *testing.T) {
+	wl := NewWindowLimiter(3, time.Second)
+	tc := &clock.TestClock{Tm: time.Now()}
+	wl.clock = tc
+
+	require.Equal(t, time.Duration(0), wl.nextDelay())
+	tc.Add(100 * time.Millisecond)
+	require.Equal(t, time.Duration(0), wl.nextDelay())
+	require.Equal(t, time.Duration(0), wl.nextDelay())
+	// 4th call waits for the first one to leave the window
+	require.Equal(t, 900*time.Millisecond, wl.nextDelay())
+	// 5th call is scheduled after the 2nd one
+	require.Equal(t, time.Second, wl.nextDelay())
+
+	tc.Add(5 * time.Second)
+	require.Equal(t, time.Duration(0), wl.nextDelay())
+}
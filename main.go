@@ -1,37 +1,74 @@
 package main
 
 import (
-	"fmt"
 	"github.com/phuslu/log"
 	"gorobei/clock"
+	"gorobei/limiter"
 	"gorobei/utils"
 	"os"
 )
 
-const DbPath = "./gorobei_db"
-
 func NewGorobeiPoster(cli *CLI) (*Gorobei, error) {
-	db, err := OpenDb(DbPath)
+	var config *Config
+	if cli.Config != "" {
+		var err error
+		config, err = LoadConfig(cli.Config)
+		if err != nil {
+			log.Error().Err(err).Msg("cannot load config")
+			return nil, err
+		}
+	}
+	db, err := OpenDb(cli.DbUrl)
 	if err != nil {
 		log.Error().Err(err).Msg("cannot open db")
 		return nil, err
 	}
-	tg, err := NewTelegram(cli.Token, db)
-	if err != nil {
-		err = db.Close()
-		return nil, err
+	if config != nil {
+		for _, s := range config.Sources {
+			db.SetRetention(s.Url, s.retention)
+		}
+	}
+	var limiterStore limiter.StateStore
+	if cli.PersistLimits {
+		limiterStore = limiter.NewFileStore(cli.LimitsFile)
+	}
+	// the offline commands work without the token
+	var tg Telegram
+	if !cli.offline() {
+		tg, err = NewTelegram(&TelegramConfig{
+			Token:   cli.Token,
+			ApiUrl:  cli.ApiUrl,
+			Proxy:   cli.Proxy,
+			Timeout: cli.Timeout,
+		}, db, limiterStore)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	var admins []*Admin
+	for _, a := range cli.Admin {
+		admins = append(admins, &Admin{User: a})
+	}
+	if config != nil {
+		admins = append(admins, config.Admins...)
 	}
 
 	g := &Gorobei{d: db,
 		tg: tg,
 		fetcher: &httpFetcherImpl{},
 		chat:         cli.Chat,
-		admin:        cli.Admin,
+		admins:       admins,
+		config:       config,
 		clock: &clock.RealClock{}}
 
+	if cli.offline() {
+		return g, nil
+	}
 	err = g.Init()
 	if err != nil {
-		err = db.Close()
+		_ = db.Close()
 		return nil, err
 	}
 	return g, nil
@@ -58,7 +95,7 @@ func main() {
 
 	log.Info().Str("token", utils.ShortenString(cli.Token, 4, 4)).Str("chat", cli.Chat).
 		Int64("chat_id", g.chatId).
-		Int64("admin_id", g.adminId).
+		Int("admins", len(g.admins)).
 		Msg("telegram Bot info")
 
 	switch cli.command {
@@ -67,7 +104,8 @@ func main() {
 		if err != nil {
 			log.Error().Err(err).Msg("cannot retrieve page content")
 			// try to notify an admin
-			_ = g.SendAdminMessage(fmt.Sprintf("Cannot get page content!\n[link](%s)\n\n__error__:\n```\n%s\n```", cli.Fetch.Url, err.Error()))
+			_ = g.Notify(NotifyFailure, SeverityError, NewMessage().Plain("Cannot get page content!\n").
+				Link("link", cli.Fetch.Url).Plain("\n\n").Underline("error").Plain(":\n").Pre(err.Error(), ""))
 			os.Exit(1)
 		}
 	case CmdSendMsg:
@@ -80,33 +118,87 @@ func main() {
 		must(err, "cannot send default chat message")
 	case CmdSendAdminMsg:
 		log.Info().Str("message", cli.SendAdminMsg.Message).Msg("send admin message params")
-		if g.admin == "" {
+		if len(g.admins) == 0 {
 			log.Error().Msg("no admin specified")
 		}
 		err = g.SendAdminMessage(cli.SendAdminMsg.Message)
 		must(err, "cannot send admin message")
 	case CmdSendImg:
 		log.Info().Str("user", cli.SendImg.Username).Str("caption", cli.SendImg.Caption).Str("path", cli.SendImg.ImagePath).Msg("send image params")
-		err = g.tg.SendImage(cli.SendImg.Username, 0, cli.SendImg.ImagePath, cli.SendImg.Caption)
+		_, err = g.tg.SendImage(cli.SendImg.Username, 0, &Image{Path: cli.SendImg.ImagePath}, cli.SendImg.Caption, nil)
 		must(err, "cannot send image")
 	case CmdSendChatImg:
 		log.Info().Str("chat", cli.Chat).Str("caption", cli.SendChatImg.Caption).Str("path", cli.SendChatImg.ImagePath).Msg("send chat image params")
 		err = g.SendChatImage(cli.SendChatImg.ImagePath, cli.SendChatImg.Caption)
 		must(err, "cannot send image to default chat")
 	case CmdForgetImg:
-		log.Info().Str("url", cli.ForgetImg.Url).Msg("forget image params")
-		err = g.ForgetImg(cli.ForgetImg.Url)
+		log.Info().Str("url", cli.ForgetImg.Url).Bool("delete", cli.ForgetImg.Delete).Msg("forget image params")
+		err = g.ForgetImg(cli.ForgetImg.Url, cli.ForgetImg.Delete)
 		must(err, "cannot forget image")
+	case CmdDeletePost:
+		log.Info().Str("url", cli.DeletePost.Url).Msg("delete post params")
+		err = g.DeletePost(cli.DeletePost.Url)
+		must(err, "cannot delete post")
+	case CmdEditCaption:
+		log.Info().Str("url", cli.EditCaption.Url).Str("text", cli.EditCaption.Text).Msg("edit caption params")
+		err = g.EditCaption(cli.EditCaption.Url, cli.EditCaption.Text)
+		must(err, "cannot edit caption")
+	case CmdResumeChat:
+		log.Info().Str("chat", cli.ResumeChat.Chat).Msg("resume chat params")
+		err = g.ResumeChat(cli.ResumeChat.Chat)
+		must(err, "cannot resume chat")
+	case CmdHistory:
+		filter := &HistoryFilter{Url: cli.History.Url, Source: cli.History.Source, Status: ImageStatus(cli.History.Status)}
+		filter.From, err = ParseHistoryDate(cli.History.From, false)
+		must(err, "invalid --from")
+		filter.To, err = ParseHistoryDate(cli.History.To, true)
+		must(err, "invalid --to")
+		if cli.History.Dest != "" {
+			filter.ChatId, err = g.resolveChat(cli.History.Dest)
+			must(err, "cannot resolve destination chat")
+		}
+		err = g.History(filter, cli.History.Format, os.Stdout)
+		must(err, "cannot read history")
+	case CmdDbExport:
+		log.Info().Str("out", cli.Db.Export.Out).Msg("db export params")
+		err = g.ExportDb(cli.Db.Export.Out)
+		must(err, "cannot export database")
+	case CmdDbImport:
+		log.Info().Str("in", cli.Db.Import.In).Str("mode", cli.Db.Import.Mode).Msg("db import params")
+		err = g.ImportDb(cli.Db.Import.In, cli.Db.Import.Mode)
+		must(err, "cannot import database")
+	case CmdDbBackup:
+		log.Info().Str("out", cli.Db.Backup.Out).Uint64("since", cli.Db.Backup.Since).Msg("db backup params")
+		err = g.BackupDb(cli.Db.Backup.Out, cli.Db.Backup.Since)
+		must(err, "cannot back up database")
+	case CmdDbRestore:
+		log.Info().Strs("files", cli.Db.Restore.Files).Msg("db restore params")
+		err = g.RestoreDb(cli.Db.Restore.Files)
+		must(err, "cannot restore database")
+	case CmdDbPrune:
+		log.Info().Bool("dry_run", cli.Db.Prune.DryRun).Msg("db prune params")
+		err = g.Prune(cli.Db.Prune.DryRun)
+		must(err, "cannot prune database")
 	case CmdReport:
 		log.Info().Msg("send report to admin")
 		var r *DailyReport
 		r, err = g.ReadOrCreateDailyReport()
 		must(err, "cannot read daily report")
-		if g.admin == "" {
+		if len(g.admins) == 0 {
 			log.Error().Msg("no admin specified")
 		}
-		err = g.SendAdminMessage(g.FormatDailyReport(r))
+		err = g.Notify(NotifyReport, SeverityInfo, g.FormatDailyReport(r))
 		must(err, "cannot read daily report")
+	case CmdDaemon:
+		log.Info().Strs("urls", cli.Daemon.Urls).Dur("interval", cli.Daemon.Interval).Str("backup_dir", cli.Daemon.BackupDir).
+			Msg("daemon params")
+		err = g.RunDaemon(cli.Daemon.Urls, cli.Daemon.Interval, cli.Daemon.Limit, &Maintenance{
+			BackupDir:      cli.Daemon.BackupDir,
+			BackupInterval: cli.Daemon.BackupInterval,
+			BackupKeep:     cli.Daemon.BackupKeep,
+			GcInterval:     cli.Daemon.GcInterval,
+		})
+		must(err, "daemon stopped with error")
 	default:
 		log.Error().Msg("invalid command")
 		os.Exit(1)
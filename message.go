@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mymmrac/telego"
+	"gorobei/utils"
+	"strings"
+)
+
+type (
+	// MessageBuilder builds a message as plain text and the list of entities, so no escaping is needed:
+	//
+	//	m := NewMessage().Bold("Error").Plain(" in ").Link("image", src).Plain(":\n").Pre(err.Error(), "")
+	//
+	MessageBuilder struct {
+		segments []segment
+	}
+
+	// segment is a piece of text, having the type of its entity or plain
+	segment struct {
+		text   string
+		entity telego.MessageEntity
+	}
+)
+
+// Entity types, https://core.telegram.org/bots/api#messageentity
+const (
+	EntityBold      = "bold"
+	EntityItalic    = "italic"
+	EntityUnderline = "underline"
+	EntityCode      = "code"
+	EntityPre       = "pre"
+	EntityTextLink  = "text_link"
+)
+
+func NewMessage() *MessageBuilder {
+	return &MessageBuilder{}
+}
+
+func (m *MessageBuilder) add(text string, entity telego.MessageEntity) *MessageBuilder {
+	if text != "" {
+		m.segments = append(m.segments, segment{text: text, entity: entity})
+	}
+	return m
+}
+
+func (m *MessageBuilder) Plain(text string) *MessageBuilder {
+	return m.add(text, telego.MessageEntity{})
+}
+
+func (m *MessageBuilder) Plainf(format string, a ...interface{}) *MessageBuilder {
+	return m.Plain(fmt.Sprintf(format, a...))
+}
+
+func (m *MessageBuilder) Bold(text string) *MessageBuilder {
+	return m.add(text, telego.MessageEntity{Type: EntityBold})
+}
+
+func (m *MessageBuilder) Italic(text string) *MessageBuilder {
+	return m.add(text, telego.MessageEntity{Type: EntityItalic})
+}
+
+func (m *MessageBuilder) Underline(text string) *MessageBuilder {
+	return m.add(text, telego.MessageEntity{Type: EntityUnderline})
+}
+
+func (m *MessageBuilder) Code(text string) *MessageBuilder {
+	return m.add(text, telego.MessageEntity{Type: EntityCode})
+}
+
+func (m *MessageBuilder) Pre(text string, language string) *MessageBuilder {
+	return m.add(text, telego.MessageEntity{Type: EntityPre, Language: language})
+}
+
+func (m *MessageBuilder) Link(text string, url string) *MessageBuilder {
+	if url == "" {
+		return m.Plain(text)
+	}
+	return m.add(text, telego.MessageEntity{Type: EntityTextLink, URL: url})
+}
+
+// Text returns the message text without any markup.
+func (m *MessageBuilder) Text() string {
+	var b strings.Builder
+	for _, s := range m.segments {
+		b.WriteString(s.text)
+	}
+	return b.String()
+}
+
+// Entities returns the message entities with offsets and lengths in UTF-16 code units.
+func (m *MessageBuilder) Entities() []telego.MessageEntity {
+	var (
+		res    []telego.MessageEntity
+		offset int
+	)
+	for _, s := range m.segments {
+		l := utils.Utf16Len(s.text)
+		if s.entity.Type != "" {
+			e := s.entity
+			e.Offset = offset
+			e.Length = l
+			res = append(res, e)
+		}
+		offset += l
+	}
+	return res
+}
+
+// Split splits the message into messages of at most limit UTF-16 code units.
+// The segments longer than limit are split into several segments of the same type.
+func (m *MessageBuilder) Split(limit int) []*MessageBuilder {
+	var (
+		res  []*MessageBuilder
+		cur  = NewMessage()
+		size int
+	)
+	for _, s := range m.segments {
+		for _, part := range utils.SplitText(s.text, limit) {
+			l := utils.Utf16Len(part)
+			if size+l > limit && size > 0 {
+				res = append(res, cur)
+				cur, size = NewMessage(), 0
+			}
+			cur.add(part, s.entity)
+			size += l
+		}
+	}
+	if size > 0 {
+		res = append(res, cur)
+	}
+	return res
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/mymmrac/telego"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestMessageBuilder(t *testing.T) {
+	m := NewMessage().Bold("😀 bold").Plain(" and ").Link("*link*", "http://a/b_c").Plain("\n").
+		Pre("`unmatched backtick", "").Code("")
+	require.Equal(t, "😀 bold and *link*\n`unmatched backtick", m.Text())
+	require.Equal(t, []telego.MessageEntity{
+		{Type: EntityBold, Offset: 0, Length: 7},
+		{Type: EntityTextLink, Offset: 12, Length: 6, URL: "http://a/b_c"},
+		{Type: EntityPre, Offset: 19, Length: 19},
+	}, m.Entities())
+}
+
+func TestMessageBuilder_Split(t *testing.T) {
+	m := NewMessage().Bold("header").Plain("\n").Pre(strings.Repeat("line\n", 4), "")
+	parts := m.Split(12)
+	require.Len(t, parts, 3)
+	require.Equal(t, "header\n", parts[0].Text())
+	require.Equal(t, []telego.MessageEntity{{Type: EntityBold, Length: 6}}, parts[0].Entities())
+	require.Equal(t, "line\nline", parts[1].Text())
+	require.Equal(t, []telego.MessageEntity{{Type: EntityPre, Length: 9}}, parts[1].Entities())
+	require.Equal(t, "line\nline", parts[2].Text())
+}
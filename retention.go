@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"github.com/phuslu/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Retention is how long the records of the source images are kept, zero keeps them forever.
+	Retention struct {
+		// Urls is the retention of the image records
+		Urls time.Duration
+		// Hashes is the retention of the content hashes, Urls is used if it is zero
+		Hashes time.Duration
+	}
+
+	// PruneStats is the number of the keys removed by Prune.
+	PruneStats struct {
+		Images int
+		Sent   int
+		Hashes int
+	}
+)
+
+// parseRetention parses the duration, 'd' suffix is the number of days, e.g. '30d'.
+func parseRetention(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var (
+		d   time.Duration
+		err error
+	)
+	if strings.HasSuffix(s, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention '%s', expected duration like '720h' or '30d'", s)
+	}
+	return d, nil
+}
+
+// hashes is the retention of the content hashes.
+func (r Retention) hashes() time.Duration {
+	if r.Hashes > 0 {
+		return r.Hashes
+	}
+	return r.Urls
+}
+
+// sent is the retention of the sent marks, the duplicates are detected by the marks of the first url,
+// so they are kept as long as the content hashes.
+func (r Retention) sent() time.Duration {
+	if r.Urls == 0 || r.hashes() == 0 {
+		return 0
+	}
+	if r.Urls > r.hashes() {
+		return r.Urls
+	}
+	return r.hashes()
+}
+
+// SetRetention sets the retention of the records stored for the source images.
+func (d *Db) SetRetention(source string, r *Retention) {
+	if d.retention == nil {
+		d.retention = make(map[string]*Retention)
+	}
+	d.retention[source] = r
+}
+
+func (d *Db) retentionOf(source string) Retention {
+	if r := d.retention[source]; r != nil {
+		return *r
+	}
+	return Retention{}
+}
+
+// expiresAt returns the expiration time of the key stored now, zero never expires.
+func (d *Db) expiresAt(ttl time.Duration) uint64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return uint64(time.Now().Add(ttl).Unix())
+}
+
+// lastActivity is the time the image was last seen or posted.
+func lastActivity(r *ImageRecord) time.Time {
+	last := r.FirstSeen
+	if r.PostedAt.After(last) {
+		last = r.PostedAt
+	}
+	for _, p := range r.Posts {
+		if p.PostedAt.After(last) {
+			last = p.PostedAt
+		}
+	}
+	return last
+}
+
+// Prune removes the records older than the retention of their source. The keys stored before the retention
+// was configured have no TTL, Prune is the way to remove them. Nothing is removed if dryRun is set.
+func (d *Db) Prune(now time.Time, dryRun bool) (*PruneStats, error) {
+	var (
+		stats   PruneStats
+		deleted [][]byte
+	)
+	err := d.b.View(func(txn kvTxn) error {
+		images := make(map[string]*ImageRecord)
+		sentExpired := make(map[string]bool)
+		prefix := []byte(nsUrl)
+		err := txn.Scan(prefix, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			url := string(key[len(prefix):])
+			r, err := decodeImageRecord(url, value)
+			if err != nil {
+				return err
+			}
+			images[url] = r
+			ret := d.retentionOf(r.Source)
+			age := now.Sub(lastActivity(r))
+			if ret.Urls > 0 && age > ret.Urls {
+				deleted = append(deleted, d.constructUrlKey(url), d.constructSeenKey(r.FirstSeen, url))
+				stats.Images++
+			}
+			if ret.sent() > 0 && age > ret.sent() {
+				sentExpired[url] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// the key is 'sent_<chat_id>_<url>'
+		prefix = []byte(nsSent)
+		err = txn.Scan(prefix, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			k := string(key[len(prefix):])
+			i := strings.Index(k, "_")
+			if i >= 0 && sentExpired[k[i+1:]] {
+				deleted = append(deleted, key)
+				stats.Sent++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// the hash is as old as the record of the first url having the content, the hashes of the urls
+		// expired by TTL are left to their own TTL
+		return txn.Scan([]byte(nsHash), nil, func(key []byte, value []byte, expiresAt uint64) error {
+			r := images[string(value)]
+			if r == nil {
+				return nil
+			}
+			ret := d.retentionOf(r.Source)
+			if ret.hashes() > 0 && now.Sub(lastActivity(r)) > ret.hashes() {
+				deleted = append(deleted, key)
+				stats.Hashes++
+			}
+			return nil
+		})
+	})
+	if err != nil || dryRun {
+		return &stats, err
+	}
+	err = d.batch(len(deleted), func(txn kvTxn, i int) error {
+		return txn.Delete(deleted[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Prune removes the records older than the retention of their sources and prints the number of the removed ones.
+func (g *Gorobei) Prune(dryRun bool) error {
+	stats, err := g.d.Prune(g.clock.Now(), dryRun)
+	if err != nil {
+		return err
+	}
+	log.Info().Int("images", stats.Images).Int("sent", stats.Sent).Int("hashes", stats.Hashes).Bool("dry_run", dryRun).
+		Msg("database pruned")
+	verb := "removed"
+	if dryRun {
+		verb = "would be removed"
+	}
+	fmt.Printf("%v image records, %v sent marks and %v content hashes %s\n", stats.Images, stats.Sent, stats.Hashes, verb)
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"":     0,
+		"30d":  30 * 24 * time.Hour,
+		"720h": 720 * time.Hour,
+		"90m":  90 * time.Minute,
+	} {
+		d, err := parseRetention(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, d, s)
+	}
+	for _, s := range []string{"30", "d", "-1h", "month"} {
+		_, err := parseRetention(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	day := 24 * time.Hour
+	assert.Equal(t, day, Retention{Urls: day}.hashes())
+	assert.Equal(t, 2*day, Retention{Urls: day, Hashes: 2 * day}.hashes())
+	assert.Equal(t, 2*day, Retention{Urls: day, Hashes: 2 * day}.sent())
+	assert.Equal(t, 2*day, Retention{Urls: 2 * day, Hashes: day}.sent())
+	assert.Zero(t, Retention{Hashes: day}.sent())
+	assert.Zero(t, Retention{}.sent())
+}
+
+func TestDb_RetentionTTL(t *testing.T) {
+	d, deferFunc := testInit(t)
+	defer deferFunc()
+	d.SetRetention("http://page", &Retention{Urls: 24 * time.Hour, Hashes: 48 * time.Hour})
+	seen := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, d.StoreImage(&ImageRecord{Url: "http://img/1.jpg", Source: "http://page", FirstSeen: seen}))
+	require.NoError(t, d.StoreImage(&ImageRecord{Url: "http://img/2.jpg", Source: "http://other", FirstSeen: seen}))
+	require.NoError(t, d.StoreUrlSent("http://img/1.jpg", "http://page", 1, 1))
+	_, err := d.StoreImageHash("abc", "http://img/1.jpg", "http://page")
+	require.NoError(t, err)
+
+	ttl := func(key []byte) time.Duration {
+		var expiresAt uint64
+		require.NoError(t, d.b.View(func(txn kvTxn) error {
+			return txn.Scan(key, nil, func(k []byte, value []byte, e uint64) error {
+				expiresAt = e
+				return errStopScan
+			})
+		}))
+		if expiresAt == 0 {
+			return 0
+		}
+		return time.Until(time.Unix(int64(expiresAt), 0)).Round(time.Hour)
+	}
+	assert.Equal(t, 24*time.Hour, ttl(d.constructUrlKey("http://img/1.jpg")))
+	assert.Equal(t, 24*time.Hour, ttl(d.constructSeenKey(seen, "http://img/1.jpg")))
+	assert.Equal(t, 48*time.Hour, ttl(d.constructUrlSentKey("http://img/1.jpg", 1)))
+	assert.Equal(t, 48*time.Hour, ttl(d.constructImageHashKey("abc")))
+	assert.Zero(t, ttl(d.constructUrlKey("http://img/2.jpg")))
+}
+
+func TestDb_Prune(t *testing.T) {
+	d, deferFunc := testInit(t)
+	defer deferFunc()
+	now := time.Date(2022, 5, 10, 10, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	store := func(url string, source string, seen time.Time, hash string) {
+		require.NoError(t, d.StoreImage(&ImageRecord{Url: url, Source: source, FirstSeen: seen, Status: ImagePosted,
+			Posts: []*ImagePost{{ChatId: -100, MessageId: 1, PostedAt: seen}}}))
+		require.NoError(t, d.StoreUrlSent(url, source, -100, 1))
+		_, err := d.StoreImageHash(hash, url, source)
+		require.NoError(t, err)
+	}
+	// the records are stored before the retention is set
+	store("http://img/old.jpg", "http://page", now.Add(-5*day), "old")
+	store("http://img/older.jpg", "http://page", now.Add(-9*day), "older")
+	store("http://img/new.jpg", "http://page", now.Add(-time.Hour), "new")
+	store("http://img/other.jpg", "http://other", now.Add(-9*day), "other")
+	d.SetRetention("http://page", &Retention{Urls: 3 * day, Hashes: 7 * day})
+
+	stats, err := d.Prune(now, true)
+	require.NoError(t, err)
+	assert.Equal(t, &PruneStats{Images: 2, Sent: 1, Hashes: 1}, stats)
+	_, err = d.ReadImage("http://img/old.jpg")
+	require.NoError(t, err)
+
+	stats, err = d.Prune(now, false)
+	require.NoError(t, err)
+	assert.Equal(t, &PruneStats{Images: 2, Sent: 1, Hashes: 1}, stats)
+	for _, url := range []string{"http://img/old.jpg", "http://img/older.jpg"} {
+		_, err = d.ReadImage(url)
+		assert.ErrorIs(t, err, ErrNotFound, url)
+	}
+	var urls []string
+	require.NoError(t, d.ReadImages(time.Time{}, time.Time{}, func(r *ImageRecord) error {
+		urls = append(urls, r.Url)
+		return nil
+	}))
+	assert.Equal(t, []string{"http://img/other.jpg", "http://img/new.jpg"}, urls)
+	// the sent marks of the old image are kept as long as its hash
+	_, err = d.ReadUrlSent("http://img/old.jpg", -100)
+	require.NoError(t, err)
+	_, err = d.ReadUrlSent("http://img/older.jpg", -100)
+	assert.ErrorIs(t, err, ErrNotFound)
+	first, err := d.StoreImageHash("old", "http://img/dup.jpg", "http://page")
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/old.jpg", first)
+	first, err = d.StoreImageHash("older", "http://img/dup.jpg", "http://page")
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/dup.jpg", first)
+
+	stats, err = d.Prune(now, false)
+	require.NoError(t, err)
+	assert.Equal(t, &PruneStats{}, stats)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/phuslu/log"
+	"strconv"
+	"strings"
+)
+
+// Key namespaces. Every key starts with its namespace, so keys of different kinds never collide
+// and each kind can be scanned by prefix.
+const (
+	nsMeta       = "meta_"
+	nsUrl        = "url_"
+	nsSent       = "sent_"
+	nsPost       = "post_"
+	nsMessage    = "msg_"
+	nsFile       = "file_"
+	nsUsername   = "username_"
+	nsUser       = "user_"
+	nsChatId     = "chatid_"
+	nsVote       = "vote_"
+	nsTally      = "tally_"
+	nsSub        = "sub_"
+	nsDigest     = "digest_"
+	nsHash       = "hash_"
+	nsSubmission = "submission_"
+	nsSuggestion = "suggestion_"
+	nsQuota      = "quota_"
+	nsMigrated   = "migrated_"
+	nsPaused     = "paused_"
+	nsSeen       = "seen_"
+)
+
+var dbKeySchemaVersion = []byte(nsMeta + "schema_version")
+
+type migration struct {
+	name  string
+	apply func(d *Db) error
+}
+
+// migrations[i] upgrades the schema from version i to i+1. The version is stored after the migration completes,
+// so an interrupted migration runs again on the next start: migrations must be idempotent.
+// Migrations must not use the namespace constants, the keys they deal with are fixed at the time they are written.
+var migrations = []migration{
+	{"move processed urls and daily report to namespaces", migrateRawKeys},
+	{"convert url status bytes to image records", migrateStatusBytes},
+	{"index image records by first seen time", indexImages},
+}
+
+// SchemaVersion returns the version of the key layout, 0 is the layout having no version record.
+func (d *Db) SchemaVersion() (int, error) {
+	var v []byte
+	err := d.b.View(func(txn kvTxn) error {
+		var err error
+		v, err = txn.Get(dbKeySchemaVersion)
+		return err
+	})
+	if errors.Is(err, errKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(v))
+}
+
+func (d *Db) storeSchemaVersion(version int) error {
+	return d.b.Update(func(txn kvTxn) error {
+		return txn.Set(dbKeySchemaVersion, []byte(strconv.Itoa(version)))
+	})
+}
+
+// Migrate upgrades the database to the current schema version.
+func (d *Db) Migrate() error {
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("cannot read schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %v is newer than supported version %v", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		m := migrations[version]
+		log.Info().Int("from", version).Int("to", version+1).Str("migration", m.name).Msg("migrating database")
+		err = m.apply(d)
+		if err != nil {
+			return fmt.Errorf("migration to version %v failed: %w", version+1, err)
+		}
+		err = d.storeSchemaVersion(version + 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveKeys renames the keys newKey returns a new name for, the keys are copied in batches.
+func (d *Db) moveKeys(newKey func(key []byte) []byte) (int, error) {
+	var (
+		keys, newKeys, values [][]byte
+		expires               []uint64
+	)
+	err := d.b.View(func(txn kvTxn) error {
+		return txn.Scan(nil, nil, func(key []byte, value []byte, expiresAt uint64) error {
+			k := newKey(key)
+			if k == nil {
+				return nil
+			}
+			keys = append(keys, key)
+			newKeys = append(newKeys, k)
+			values = append(values, value)
+			expires = append(expires, expiresAt)
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = d.batch(len(keys), func(txn kvTxn, i int) error {
+		err := txn.SetEntry(newKeys[i], values[i], expires[i])
+		if err != nil {
+			return err
+		}
+		return txn.Delete(keys[i])
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// migrateRawKeys moves the processed urls stored as raw keys to 'url_' namespace and 'daily_report'
+// to 'meta_daily_report'.
+func migrateRawKeys(d *Db) error {
+	namespaces := []string{"meta_", "url_", "sent_", "post_", "msg_", "file_", "username_", "user_", "chatid_",
+		"limiter_", "vote_", "tally_", "sub_", "digest_", "hash_", "submission_", "suggestion_", "quota_",
+		"migrated_", "paused_"}
+	moved, err := d.moveKeys(func(key []byte) []byte {
+		k := string(key)
+		if k == "daily_report" {
+			return []byte("meta_daily_report")
+		}
+		for _, ns := range namespaces {
+			if strings.HasPrefix(k, ns) {
+				return nil
+			}
+		}
+		return []byte("url_" + k)
+	})
+	if err == nil {
+		log.Info().Int("keys", moved).Msg("raw keys moved to namespaces")
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type (
+	// MediaStore keeps the processed images and the messages they were posted as.
+	MediaStore interface {
+		ReadImage(url string) (*ImageRecord, error)
+		StoreImage(r *ImageRecord) error
+		UpdateImage(url string, f func(r *ImageRecord)) error
+		ReadImages(from time.Time, to time.Time, f func(r *ImageRecord) error) error
+		ReadUrlSent(url string, chatId int64) (byte, error)
+		StoreUrlSent(url string, source string, chatId int64, value byte) error
+		StoreImageHash(hash string, url string, source string) (string, error)
+		StorePost(url string, m *SentMessage) error
+		ReadPosts(url string) ([]*SentMessage, error)
+		DeletePost(url string, chatId int64) error
+		ReadMessageUrl(chatId int64, messageId int) (string, error)
+		StoreImageFile(url string, m *SentMessage) error
+		ReadImageFile(url string) (*SentMessage, error)
+	}
+
+	// ReportStore keeps the daily report and the votes for the posted images.
+	ReportStore interface {
+		StoreDailyReport(r *DailyReport) error
+		ReadDailyReport() (*DailyReport, error)
+		StoreVote(chatId int64, messageId int, userId int64, up bool) (*VoteTally, error)
+		ReadTally(chatId int64, messageId int) (*VoteTally, error)
+		TopVoted(n int) ([]*VoteTally, error)
+	}
+
+	// QueueStore keeps the images waiting to be posted or sent: submissions, suggestions and digests.
+	QueueStore interface {
+		StoreSubmission(userId int64, messageId int, s *Submission) error
+		ReadSubmission(userId int64, messageId int) (*Submission, error)
+		StoreSuggestion(s *Suggestion) error
+		SuggestWithQuota(s *Suggestion, day string, limit int) (bool, error)
+		TakeSuggestion(userId int64, messageId int) (*Suggestion, error)
+		ReadSuggestions() ([]*Suggestion, error)
+		TakeQuota(userId int64, day string, limit int) (bool, error)
+		QueueDigest(userId int64, url string, fileId string) error
+		ReadDigest(userId int64) ([]string, error)
+		DeleteDigest(userId int64, fileIds []string) error
+		ClearDigest(userId int64) error
+	}
+
+	// SettingsStore keeps the state of the chats and the subscriptions.
+	SettingsStore interface {
+		StoreSubscription(s *Subscription) error
+		DeleteSubscription(source string, userId int64) error
+		ReadSubscriptions(source string) ([]*Subscription, error)
+		// StoreChatId caches the ID of the '@name' chat, ReadChatId of UsersStore reads it
+		StoreChatId(name string, id int64) error
+		MigrateChat(from int64, to int64) error
+		ReadChatMigration(chatId int64) (int64, error)
+		StoreChatPause(chatId int64, reason string) error
+		ReadChatPause(chatId int64) (string, error)
+		DeleteChatPause(chatId int64) error
+		SetRetention(source string, r *Retention)
+	}
+
+	// Store is the storage of the Bot.
+	Store interface {
+		UsersStore
+		MediaStore
+		ReportStore
+		QueueStore
+		SettingsStore
+		Export(w io.Writer) (int, error)
+		Import(r io.Reader, mode string) (int, error)
+		Backup(path string, since uint64) (uint64, error)
+		Restore(paths []string) error
+		Prune(now time.Time, dryRun bool) (*PruneStats, error)
+		RunGC() error
+		Close() error
+	}
+
+	// kvTxn is the transaction of the key-value engine the Db is stored in.
+	kvTxn interface {
+		// Get returns errKeyNotFound if the key is missing or expired
+		Get(key []byte) ([]byte, error)
+		Set(key []byte, value []byte) error
+		// SetEntry stores the key expiring at expiresAt unix time, zero never expires
+		SetEntry(key []byte, value []byte, expiresAt uint64) error
+		Delete(key []byte) error
+		// Scan calls f for the keys having the prefix in the key order starting from seek,
+		// errStopScan returned by f stops the scan. The keys must not be modified during the scan.
+		Scan(prefix []byte, seek []byte, f func(key []byte, value []byte, expiresAt uint64) error) error
+	}
+
+	// kvEngine is the key-value engine, Update is retried by the engine if the transaction conflicts with another one.
+	kvEngine interface {
+		View(f func(txn kvTxn) error) error
+		Update(f func(txn kvTxn) error) error
+		DropAll() error
+		Close() error
+	}
+
+	// kvBackuper is the engine supporting native backups, since is the version of the previous backup.
+	kvBackuper interface {
+		Backup(w io.Writer, since uint64) (uint64, error)
+		Load(r io.Reader) error
+	}
+
+	// kvCollector is the engine reclaiming the space of the deleted keys.
+	kvCollector interface {
+		RunGC() error
+	}
+)
+
+const (
+	SchemeBadger = "badger"
+	SchemeBolt   = "bolt"
+	SchemeMemory = "memory"
+
+	// kvBatchSize is the number of the keys written by one transaction of the batch
+	kvBatchSize = 1000
+)
+
+var (
+	errKeyNotFound = errors.New("kv: key not found")
+	errStopScan    = errors.New("kv: stop scan")
+	errReadOnlyTxn = errors.New("kv: read-only transaction")
+
+	_ Store = (*Db)(nil)
+)
+
+// OpenDb opens the database by url: 'badger://path' is the badger directory, 'bolt://path' is the bbolt file
+// and 'memory://' is the in-memory storage lost on exit. The url without a scheme is the badger directory.
+func OpenDb(url string) (*Db, error) {
+	scheme, path := SchemeBadger, url
+	if i := strings.Index(url, "://"); i >= 0 {
+		scheme, path = url[:i], url[i+3:]
+	}
+	var (
+		e   kvEngine
+		err error
+	)
+	switch scheme {
+	case SchemeBadger:
+		e, err = openBadger(path)
+	case SchemeBolt:
+		e, err = openBolt(path)
+	case SchemeMemory:
+		e = newMemoryEngine()
+	default:
+		return nil, fmt.Errorf("unknown database scheme '%s', expected badger, bolt or memory", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db := &Db{b: e}
+	err = db.Migrate()
+	if err != nil {
+		_ = e.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// batch calls write for n keys in transactions of kvBatchSize keys, so large writes fit the engine limits.
+func (d *Db) batch(n int, write func(txn kvTxn, i int) error) error {
+	for start := 0; start < n; start += kvBatchSize {
+		end := start + kvBatchSize
+		if end > n {
+			end = n
+		}
+		err := d.b.Update(func(txn kvTxn) error {
+			for i := start; i < end; i++ {
+				err := write(txn, i)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expired checks if the key expiring at expiresAt unix time has expired.
+func expired(expiresAt uint64, now time.Time) bool {
+	return expiresAt != 0 && expiresAt <= uint64(now.Unix())
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/phuslu/log"
+	"io"
+)
+
+type (
+	badgerEngine struct {
+		b *badger.DB
+	}
+
+	badgerTxn struct {
+		txn *badger.Txn
+	}
+)
+
+var (
+	_ kvEngine      = (*badgerEngine)(nil)
+	_ kvBackuper    = (*badgerEngine)(nil)
+	_ kvCollector   = (*badgerEngine)(nil)
+	_ badger.Logger = (*LogAdapter)(nil)
+)
+
+// maxPendingWrites limits the memory used by restore
+const maxPendingWrites = 256
+
+func openBadger(path string) (*badgerEngine, error) {
+	log.Info().Str("path", path).Msg("opening database")
+	opts := badger.DefaultOptions(path).
+		WithValueLogFileSize(10 * 1024 * 1024).
+		WithLogger(&LogAdapter{&log.DefaultLogger})
+
+	b, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.RunValueLogGC(0.5)
+	if err != nil && !errors.Is(err, badger.ErrNoRewrite) {
+		_ = b.Close()
+		return nil, err
+	}
+	return &badgerEngine{b: b}, nil
+}
+
+func (e *badgerEngine) View(f func(txn kvTxn) error) error {
+	return e.b.View(func(txn *badger.Txn) error {
+		return f(&badgerTxn{txn})
+	})
+}
+
+func (e *badgerEngine) Update(f func(txn kvTxn) error) error {
+	for {
+		err := e.b.Update(func(txn *badger.Txn) error {
+			return f(&badgerTxn{txn})
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+}
+
+func (e *badgerEngine) DropAll() error {
+	return e.b.DropAll()
+}
+
+func (e *badgerEngine) Close() error {
+	return e.b.Close()
+}
+
+func (e *badgerEngine) Backup(w io.Writer, since uint64) (uint64, error) {
+	return e.b.Backup(w, since)
+}
+
+func (e *badgerEngine) Load(r io.Reader) error {
+	return e.b.Load(r, maxPendingWrites)
+}
+
+// RunGC rewrites the value log files until there is nothing to reclaim.
+func (e *badgerEngine) RunGC() error {
+	for {
+		err := e.b.RunValueLogGC(0.5)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *badgerTxn) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, errKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (t *badgerTxn) Set(key []byte, value []byte) error {
+	return t.txn.Set(key, value)
+}
+
+func (t *badgerTxn) SetEntry(key []byte, value []byte, expiresAt uint64) error {
+	e := badger.NewEntry(key, value)
+	e.ExpiresAt = expiresAt
+	return t.txn.SetEntry(e)
+}
+
+func (t *badgerTxn) Delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (t *badgerTxn) Scan(prefix []byte, seek []byte, f func(key []byte, value []byte, expiresAt uint64) error) error {
+	it := t.txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+	defer it.Close()
+	if bytes.Compare(seek, prefix) < 0 {
+		seek = prefix
+	}
+	for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		err = f(item.KeyCopy(nil), v, item.ExpiresAt())
+		if errors.Is(err, errStopScan) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
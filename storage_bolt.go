@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/phuslu/log"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"os"
+	"time"
+)
+
+type (
+	// boltEngine keeps the keys in a single bbolt file. The value is prefixed with the expiration time,
+	// the expired keys are skipped on read and deleted by RunGC.
+	boltEngine struct {
+		b *bolt.DB
+	}
+
+	boltTxn struct {
+		bucket *bolt.Bucket
+	}
+)
+
+var (
+	_ kvEngine    = (*boltEngine)(nil)
+	_ kvBackuper  = (*boltEngine)(nil)
+	_ kvCollector = (*boltEngine)(nil)
+
+	boltBucket = []byte("gorobei")
+
+	errBoltIncremental = errors.New("bolt storage supports full backups only")
+)
+
+func openBolt(path string) (*boltEngine, error) {
+	log.Info().Str("path", path).Msg("opening database")
+	// the file is locked by another process if the open doesn't complete in time
+	b, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = b.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		_ = b.Close()
+		return nil, err
+	}
+	return &boltEngine{b: b}, nil
+}
+
+func (e *boltEngine) View(f func(txn kvTxn) error) error {
+	return e.b.View(func(tx *bolt.Tx) error {
+		return f(&boltTxn{tx.Bucket(boltBucket)})
+	})
+}
+
+func (e *boltEngine) Update(f func(txn kvTxn) error) error {
+	return e.b.Update(func(tx *bolt.Tx) error {
+		return f(&boltTxn{tx.Bucket(boltBucket)})
+	})
+}
+
+func (e *boltEngine) DropAll() error {
+	return e.b.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(boltBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket(boltBucket)
+		return err
+	})
+}
+
+func (e *boltEngine) Close() error {
+	return e.b.Close()
+}
+
+// Backup writes the copy of the database file, the version is the transaction ID of the copy.
+func (e *boltEngine) Backup(w io.Writer, since uint64) (uint64, error) {
+	if since != 0 {
+		return 0, errBoltIncremental
+	}
+	var version uint64
+	err := e.b.View(func(tx *bolt.Tx) error {
+		version = uint64(tx.ID())
+		_, err := tx.WriteTo(w)
+		return err
+	})
+	return version, err
+}
+
+// Load copies the keys of the backup file into the database.
+func (e *boltEngine) Load(r io.Reader) error {
+	tmp, err := os.CreateTemp("", "gorobei-restore-*.db")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	src, err := bolt.Open(tmp.Name(), 0o600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return src.View(func(stx *bolt.Tx) error {
+		from := stx.Bucket(boltBucket)
+		if from == nil {
+			return errors.New("the backup has no gorobei bucket")
+		}
+		return e.b.Update(func(tx *bolt.Tx) error {
+			to := tx.Bucket(boltBucket)
+			return from.ForEach(func(k, v []byte) error {
+				return to.Put(append([]byte{}, k...), append([]byte{}, v...))
+			})
+		})
+	})
+}
+
+// RunGC deletes the expired keys.
+func (e *boltEngine) RunGC() error {
+	now := time.Now()
+	return e.b.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.First(); k != nil; {
+			if len(v) >= 8 && expired(binary.BigEndian.Uint64(v), now) {
+				err := c.Delete()
+				if err != nil {
+					return err
+				}
+				// the cursor moves to the next key after the deletion
+				k, v = c.Seek(k)
+				continue
+			}
+			k, v = c.Next()
+		}
+		return nil
+	})
+}
+
+func encodeBoltValue(value []byte, expiresAt uint64) []byte {
+	v := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(v, expiresAt)
+	copy(v[8:], value)
+	return v
+}
+
+// decodeBoltValue returns the value or nil if it has expired.
+func decodeBoltValue(v []byte, now time.Time) ([]byte, uint64, error) {
+	if len(v) < 8 {
+		return nil, 0, errors.New("bolt value is too short")
+	}
+	expiresAt := binary.BigEndian.Uint64(v)
+	if expired(expiresAt, now) {
+		return nil, expiresAt, nil
+	}
+	return append([]byte{}, v[8:]...), expiresAt, nil
+}
+
+func (t *boltTxn) Get(key []byte) ([]byte, error) {
+	v := t.bucket.Get(key)
+	if v == nil {
+		return nil, errKeyNotFound
+	}
+	value, _, err := decodeBoltValue(v, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, errKeyNotFound
+	}
+	return value, nil
+}
+
+func (t *boltTxn) Set(key []byte, value []byte) error {
+	return t.SetEntry(key, value, 0)
+}
+
+func (t *boltTxn) SetEntry(key []byte, value []byte, expiresAt uint64) error {
+	return t.bucket.Put(key, encodeBoltValue(value, expiresAt))
+}
+
+func (t *boltTxn) Delete(key []byte) error {
+	return t.bucket.Delete(key)
+}
+
+func (t *boltTxn) Scan(prefix []byte, seek []byte, f func(key []byte, value []byte, expiresAt uint64) error) error {
+	if bytes.Compare(seek, prefix) < 0 {
+		seek = prefix
+	}
+	now := time.Now()
+	c := t.bucket.Cursor()
+	for k, v := c.Seek(seek); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		value, expiresAt, err := decodeBoltValue(v, now)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		err = f(append([]byte{}, k...), value, expiresAt)
+		if errors.Is(err, errStopScan) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
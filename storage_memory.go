@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// memoryEngine keeps the keys in memory, it is used by the tests and by the runs not needing the state.
+	memoryEngine struct {
+		mu   sync.RWMutex
+		keys map[string]*memoryEntry
+	}
+
+	memoryEntry struct {
+		value     []byte
+		expiresAt uint64
+	}
+
+	// memoryTxn collects the writes and applies them on commit, nil entry is the deleted key.
+	memoryTxn struct {
+		e      *memoryEngine
+		writes map[string]*memoryEntry
+	}
+)
+
+var _ kvEngine = (*memoryEngine)(nil)
+
+func newMemoryEngine() *memoryEngine {
+	return &memoryEngine{keys: make(map[string]*memoryEntry)}
+}
+
+func (e *memoryEngine) View(f func(txn kvTxn) error) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return f(&memoryTxn{e: e})
+}
+
+func (e *memoryEngine) Update(f func(txn kvTxn) error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	txn := &memoryTxn{e: e, writes: make(map[string]*memoryEntry)}
+	err := f(txn)
+	if err != nil {
+		return err
+	}
+	for k, v := range txn.writes {
+		if v == nil {
+			delete(e.keys, k)
+		} else {
+			e.keys[k] = v
+		}
+	}
+	return nil
+}
+
+func (e *memoryEngine) DropAll() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keys = make(map[string]*memoryEntry)
+	return nil
+}
+
+func (e *memoryEngine) Close() error {
+	return nil
+}
+
+func (t *memoryTxn) entry(key string) *memoryEntry {
+	v, ok := t.writes[key]
+	if !ok {
+		v = t.e.keys[key]
+	}
+	if v == nil || expired(v.expiresAt, time.Now()) {
+		return nil
+	}
+	return v
+}
+
+func (t *memoryTxn) Get(key []byte) ([]byte, error) {
+	v := t.entry(string(key))
+	if v == nil {
+		return nil, errKeyNotFound
+	}
+	return append([]byte{}, v.value...), nil
+}
+
+func (t *memoryTxn) Set(key []byte, value []byte) error {
+	return t.SetEntry(key, value, 0)
+}
+
+func (t *memoryTxn) SetEntry(key []byte, value []byte, expiresAt uint64) error {
+	if t.writes == nil {
+		return errReadOnlyTxn
+	}
+	t.writes[string(key)] = &memoryEntry{value: append([]byte{}, value...), expiresAt: expiresAt}
+	return nil
+}
+
+func (t *memoryTxn) Delete(key []byte) error {
+	if t.writes == nil {
+		return errReadOnlyTxn
+	}
+	t.writes[string(key)] = nil
+	return nil
+}
+
+func (t *memoryTxn) Scan(prefix []byte, seek []byte, f func(key []byte, value []byte, expiresAt uint64) error) error {
+	if bytes.Compare(seek, prefix) < 0 {
+		seek = prefix
+	}
+	var keys []string
+	add := func(k string) {
+		if bytes.HasPrefix([]byte(k), prefix) && k >= string(seek) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range t.e.keys {
+		add(k)
+	}
+	for k := range t.writes {
+		if _, ok := t.e.keys[k]; !ok {
+			add(k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := t.entry(k)
+		if v == nil {
+			continue
+		}
+		err := f([]byte(k), append([]byte{}, v.value...), v.expiresAt)
+		if errors.Is(err, errStopScan) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testEngines(t *testing.T) map[string]string {
+	dir := t.TempDir()
+	return map[string]string{
+		SchemeBadger: "badger://" + filepath.Join(dir, "badger"),
+		SchemeBolt:   "bolt://" + filepath.Join(dir, "gorobei.db"),
+		SchemeMemory: "memory://",
+	}
+}
+
+func scanKeys(t *testing.T, e kvEngine, prefix string, seek string) []string {
+	var res []string
+	require.NoError(t, e.View(func(txn kvTxn) error {
+		var s []byte
+		if seek != "" {
+			s = []byte(seek)
+		}
+		return txn.Scan([]byte(prefix), s, func(key []byte, value []byte, expiresAt uint64) error {
+			res = append(res, string(key)+"="+string(value))
+			return nil
+		})
+	}))
+	return res
+}
+
+func TestStorage_Engines(t *testing.T) {
+	initLog(false)
+	for scheme, url := range testEngines(t) {
+		t.Run(scheme, func(t *testing.T) {
+			d, err := OpenDb(url)
+			require.NoError(t, err)
+			defer d.Close()
+			e := d.b
+			require.NoError(t, e.DropAll())
+
+			require.NoError(t, e.Update(func(txn kvTxn) error {
+				for _, k := range []string{"b_2", "a_1", "b_1", "c_1", "b_3"} {
+					err := txn.Set([]byte(k), []byte(k[2:]))
+					if err != nil {
+						return err
+					}
+				}
+				// the writes of the transaction are visible to it
+				v, err := txn.Get([]byte("b_2"))
+				require.NoError(t, err)
+				assert.Equal(t, []byte("2"), v)
+				return txn.SetEntry([]byte("b_0"), []byte("0"), uint64(time.Now().Add(-time.Minute).Unix()))
+			}))
+			assert.Equal(t, []string{"b_1=1", "b_2=2", "b_3=3"}, scanKeys(t, e, "b_", ""))
+			assert.Equal(t, []string{"b_2=2", "b_3=3"}, scanKeys(t, e, "b_", "b_2"))
+			assert.Equal(t, []string{"a_1=1", "b_1=1", "b_2=2", "b_3=3", "c_1=1"}, scanKeys(t, e, "", ""))
+
+			// the expired key is missing
+			require.NoError(t, e.View(func(txn kvTxn) error {
+				_, err := txn.Get([]byte("b_0"))
+				assert.ErrorIs(t, err, errKeyNotFound)
+				return nil
+			}))
+
+			var first []string
+			require.NoError(t, e.View(func(txn kvTxn) error {
+				return txn.Scan([]byte("b_"), nil, func(key []byte, value []byte, expiresAt uint64) error {
+					first = append(first, string(key))
+					return errStopScan
+				})
+			}))
+			assert.Equal(t, []string{"b_1"}, first)
+
+			// nothing is written if the transaction fails
+			errTest := errors.New("test")
+			err = e.Update(func(txn kvTxn) error {
+				require.NoError(t, txn.Delete([]byte("a_1")))
+				require.NoError(t, txn.Set([]byte("d_1"), []byte("1")))
+				return errTest
+			})
+			require.ErrorIs(t, err, errTest)
+			assert.Equal(t, []string{"a_1=1"}, scanKeys(t, e, "a_", ""))
+			assert.Empty(t, scanKeys(t, e, "d_", ""))
+
+			require.NoError(t, e.Update(func(txn kvTxn) error {
+				return txn.Delete([]byte("a_1"))
+			}))
+			assert.Empty(t, scanKeys(t, e, "a_", ""))
+
+			require.NoError(t, e.DropAll())
+			assert.Empty(t, scanKeys(t, e, "", ""))
+			require.NoError(t, d.RunGC())
+		})
+	}
+}
+
+func TestStorage_Db(t *testing.T) {
+	initLog(false)
+	for scheme, url := range testEngines(t) {
+		t.Run(scheme, func(t *testing.T) {
+			d, err := OpenDb(url)
+			require.NoError(t, err)
+			defer d.Close()
+			fillTestDb(t, d)
+
+			r, err := d.ReadImage("http://img/1.jpg?a=1&b=2")
+			require.NoError(t, err)
+			assert.Equal(t, ImagePosted, r.Status)
+			id, err := d.ReadUserId("john")
+			require.NoError(t, err)
+			assert.Equal(t, int64(100), id)
+			ok, err := d.TakeQuota(100, "2022-05-01", 1)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			assert.Contains(t, dbKeys(t, d), "seen_01651399200000000000_http://img/1.jpg?a=1&b=2")
+		})
+	}
+}
+
+func TestStorage_BoltBackup(t *testing.T) {
+	initLog(false)
+	dir := t.TempDir()
+	d, err := OpenDb("bolt://" + filepath.Join(dir, "gorobei.db"))
+	require.NoError(t, err)
+	defer d.Close()
+	fillTestDb(t, d)
+
+	full := filepath.Join(dir, "full.bak")
+	since, err := d.Backup(full, 0)
+	require.NoError(t, err)
+	_, err = d.Backup(filepath.Join(dir, "inc.bak"), since)
+	require.ErrorIs(t, err, errBoltIncremental)
+
+	d2, err := OpenDb("bolt://" + filepath.Join(dir, "restore.db"))
+	require.NoError(t, err)
+	defer d2.Close()
+	require.NoError(t, d2.StoreChatPause(-300, "kicked"))
+	require.NoError(t, d2.Restore([]string{full}))
+	assert.Equal(t, dbKeys(t, d), dbKeys(t, d2))
+}
+
+func TestStorage_MemoryNoBackup(t *testing.T) {
+	d, deferFunc := testInit(t)
+	defer deferFunc()
+	_, err := d.Backup(filepath.Join(t.TempDir(), "full.bak"), 0)
+	require.ErrorIs(t, err, errNoBackups)
+	require.ErrorIs(t, d.Restore(nil), errNoBackups)
+}
+
+func TestOpenDb_UnknownScheme(t *testing.T) {
+	_, err := OpenDb("mysql://localhost")
+	require.Error(t, err)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mymmrac/telego"
+	"github.com/phuslu/log"
+	"gorobei/utils"
+	"strconv"
+	"strings"
+)
+
+// CallbackPost is the prefix of the destination buttons: 'post:<message_id>:<chat_id>'
+const CallbackPost = "post:"
+
+// maxCallbackAnswer is the length limit of the callback query answer
+const maxCallbackAnswer = 200
+
+// submissionSrc is the prefix of the urls identifying the files sent to the Bot
+const submissionSrc = "tg:"
+
+func (g *Gorobei) isAdmin(userId int64) bool {
+	for _, a := range g.admins {
+		if a.id != 0 && a.id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSubmission returns the image the message contains or nil if there is none.
+func parseSubmission(m *telego.Message) *Submission {
+	switch {
+	case len(m.Photo) > 0:
+		// the last one is the largest size
+		p := m.Photo[len(m.Photo)-1]
+		return &Submission{FileId: p.FileID, FileUniqueId: p.FileUniqueID, Caption: m.Caption}
+	case m.Document != nil && strings.HasPrefix(m.Document.MimeType, "image/"):
+		return &Submission{FileId: m.Document.FileID, FileUniqueId: m.Document.FileUniqueID, Document: true, Caption: m.Caption}
+	}
+	args := strings.Fields(m.Text)
+	if len(args) == 0 || !(strings.HasPrefix(args[0], "http://") || strings.HasPrefix(args[0], "https://")) {
+		return nil
+	}
+	caption := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(m.Text), args[0]))
+	return &Submission{Url: args[0], Caption: caption}
+}
+
+// Src returns the url the submission is deduplicated by.
+func (s *Submission) Src() string {
+	if s.Url != "" {
+		return s.Url
+	}
+	return submissionSrc + s.FileUniqueId
+}
+
+// submissionDestinations returns the default chat and the chats of the config sources.
+func (g *Gorobei) submissionDestinations() []*Destination {
+	res := []*Destination{{Chat: g.chat, chatId: g.chatId}}
+	seen := map[int64]bool{g.chatId: true}
+	if g.config == nil {
+		return res
+	}
+	for _, d := range g.config.Destinations() {
+		if !seen[d.chatId] {
+			seen[d.chatId] = true
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// handleSubmission asks the admin which chat to post the image to. It returns false if the message has no image.
+func (g *Gorobei) handleSubmission(m *telego.Message) bool {
+	if m.Chat.Type != "private" || m.From == nil || !g.isAdmin(m.From.ID) {
+		return false
+	}
+	s := parseSubmission(m)
+	if s == nil {
+		return false
+	}
+	err := g.d.StoreSubmission(m.Chat.ID, m.MessageID, s)
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", m.Chat.ID).Msg("cannot store submission")
+		return true
+	}
+	var rows [][]telego.InlineKeyboardButton
+	for _, d := range g.submissionDestinations() {
+		rows = append(rows, []telego.InlineKeyboardButton{{
+			Text:         d.Chat,
+			CallbackData: fmt.Sprintf("%s%v:%v", CallbackPost, m.MessageID, d.chatId),
+		}})
+	}
+	err = g.tg.SendKeyboard(m.Chat.ID, "Where to post the image?", &telego.InlineKeyboardMarkup{InlineKeyboard: rows})
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", m.Chat.ID).Msg("cannot ask for the destination")
+	}
+	return true
+}
+
+// handlePostCallback posts the submission to the chat the admin has chosen.
+func (g *Gorobei) handlePostCallback(q *telego.CallbackQuery) {
+	answer := g.postSubmission(q)
+	err := g.tg.AnswerCallback(q.ID, answer)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot answer callback query")
+	}
+}
+
+func (g *Gorobei) postSubmission(q *telego.CallbackQuery) string {
+	if !g.isAdmin(q.From.ID) {
+		return "Only admins can post images."
+	}
+	args := strings.Split(strings.TrimPrefix(q.Data, CallbackPost), ":")
+	if len(args) != 2 {
+		log.Error().Str("data", q.Data).Msg("unexpected callback data")
+		return "Sorry, something went wrong."
+	}
+	messageId, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Error().Err(err).Str("data", q.Data).Msg("unexpected callback data")
+		return "Sorry, something went wrong."
+	}
+	chatId, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		log.Error().Err(err).Str("data", q.Data).Msg("unexpected callback data")
+		return "Sorry, something went wrong."
+	}
+	var dest *Destination
+	for _, d := range g.submissionDestinations() {
+		if d.chatId == chatId {
+			dest = d
+		}
+	}
+	if dest == nil {
+		return "The chat is not configured anymore."
+	}
+	s, err := g.d.ReadSubmission(q.From.ID, messageId)
+	if errors.Is(err, ErrNotFound) {
+		return "The image is not found, send it again."
+	}
+	if err != nil {
+		log.Error().Err(err).Int("message_id", messageId).Msg("cannot read submission")
+		return "Sorry, something went wrong."
+	}
+	err = g.postImage(s.Src(), "", s.Caption, []*Destination{dest}, func(src string) (*Image, error) {
+		return g.submissionImage(s)
+	})
+	switch {
+	case errors.Is(err, ErrImageAlreadyProcessed):
+		return fmt.Sprintf("The image has been posted to %s already.", dest.Chat)
+	case errors.Is(err, ErrChatsPaused):
+		return fmt.Sprintf("Posting to %s is paused.", dest.Chat)
+	case err != nil:
+		log.Error().Err(err).Str("src", s.Src()).Str("chat", dest.Chat).Msg("cannot post submission")
+		return utils.FirstN("Cannot post the image: "+err.Error(), maxCallbackAnswer)
+	}
+	return fmt.Sprintf("Posted to %s.", dest.Chat)
+}
+
+// submissionImage downloads the image, so its content hash can be checked.
+// Photos are reposted by file_id, documents are uploaded as photos.
+func (g *Gorobei) submissionImage(s *Submission) (*Image, error) {
+	if s.Url != "" {
+		path, err := g.fetcher.FetchImage(s.Url)
+		if err != nil {
+			return nil, err
+		}
+		return &Image{Path: path}, nil
+	}
+	path, err := g.tg.DownloadFile(s.FileId)
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{Path: path}
+	if !s.Document {
+		image.FileId = s.FileId
+	}
+	return image, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mymmrac/telego"
+	"github.com/phuslu/log"
+	"strings"
+)
+
+const (
+	CmdSubscribe   = "/subscribe"
+	CmdUnsubscribe = "/unsubscribe"
+)
+
+// handleCommand processes the commands users send to the Bot in private chats.
+func (g *Gorobei) handleCommand(m *telego.Message) {
+	if m.Chat.Type != "private" {
+		return
+	}
+	args := strings.Fields(m.Text)
+	if len(args) == 0 {
+		return
+	}
+	// commands may be sent as '/subscribe@bot_name'
+	cmd := strings.SplitN(args[0], "@", 2)[0]
+	var reply string
+	switch cmd {
+	case CmdSubscribe:
+		reply = g.subscribe(m.Chat.ID, args[1:])
+	case CmdUnsubscribe:
+		reply = g.unsubscribe(m.Chat.ID, args[1:])
+	case CmdReview:
+		if !g.isAdmin(m.Chat.ID) {
+			return
+		}
+		reply = g.sendReviewQueue(m.Chat.ID)
+	default:
+		return
+	}
+	err := g.tg.SendMessageText("", m.Chat.ID, reply)
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", m.Chat.ID).Msg("cannot reply to the user")
+	}
+}
+
+func (g *Gorobei) sourceNames() []string {
+	var res []string
+	if g.config == nil {
+		return res
+	}
+	for _, s := range g.config.Sources {
+		if s.Name != "" {
+			res = append(res, s.Name)
+		} else {
+			res = append(res, s.Url)
+		}
+	}
+	return res
+}
+
+func (g *Gorobei) subscribe(userId int64, args []string) string {
+	usage := fmt.Sprintf("Usage: %s <source> [digest]\nAvailable sources: %s", CmdSubscribe, strings.Join(g.sourceNames(), ", "))
+	if len(args) == 0 || len(args) > 2 {
+		return usage
+	}
+	src := g.config.SourceByName(args[0])
+	if src == nil {
+		return fmt.Sprintf("Unknown source '%s'.\n%s", args[0], usage)
+	}
+	s := &Subscription{UserId: userId, Source: src.Url}
+	if len(args) == 2 {
+		if !strings.EqualFold(args[1], "digest") {
+			return usage
+		}
+		s.Digest = true
+	}
+	err := g.d.StoreSubscription(s)
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", userId).Msg("cannot store subscription")
+		return "Sorry, something went wrong."
+	}
+	if s.Digest {
+		return fmt.Sprintf("You will receive the daily digest of '%s'.", args[0])
+	}
+	return fmt.Sprintf("You will receive new images of '%s'.", args[0])
+}
+
+func (g *Gorobei) unsubscribe(userId int64, args []string) string {
+	if len(args) == 0 {
+		err := g.removeSubscriber(userId)
+		if err != nil {
+			log.Error().Err(err).Int64("user_id", userId).Msg("cannot remove subscriptions")
+			return "Sorry, something went wrong."
+		}
+		return "You have unsubscribed from all sources."
+	}
+	src := g.config.SourceByName(args[0])
+	if src == nil {
+		return fmt.Sprintf("Unknown source '%s'.", args[0])
+	}
+	err := g.d.DeleteSubscription(src.Url, userId)
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", userId).Msg("cannot remove subscription")
+		return "Sorry, something went wrong."
+	}
+	return fmt.Sprintf("You have unsubscribed from '%s'.", args[0])
+}
+
+// removeSubscriber deletes all subscriptions and the pending digest of the user.
+func (g *Gorobei) removeSubscriber(userId int64) error {
+	subs, err := g.d.ReadSubscriptions("")
+	if err != nil {
+		return err
+	}
+	for _, s := range subs {
+		if s.UserId != userId {
+			continue
+		}
+		err = g.d.DeleteSubscription(s.Source, userId)
+		if err != nil {
+			return err
+		}
+	}
+	return g.d.ClearDigest(userId)
+}
+
+// notifySubscribers sends the uploaded image to the users subscribed to the source or queues it for the digest.
+func (g *Gorobei) notifySubscribers(source string, src string, fileId string) {
+	subs, err := g.d.ReadSubscriptions(source)
+	if err != nil {
+		log.Error().Err(err).Str("source", source).Msg("cannot read subscriptions")
+		return
+	}
+	for _, s := range subs {
+		if s.Digest {
+			err = g.d.QueueDigest(s.UserId, src, fileId)
+		} else {
+			_, err = g.tg.SendImage("", s.UserId, &Image{FileId: fileId}, "", nil)
+		}
+		g.checkSubscriberError(s.UserId, err)
+	}
+}
+
+// SendDigests sends the queued images to the digest subscribers.
+func (g *Gorobei) SendDigests() {
+	subs, err := g.d.ReadSubscriptions("")
+	if err != nil {
+		log.Error().Err(err).Msg("cannot read subscriptions")
+		return
+	}
+	sent := make(map[int64]bool)
+	for _, s := range subs {
+		if !s.Digest || sent[s.UserId] {
+			continue
+		}
+		sent[s.UserId] = true
+		files, err := g.d.ReadDigest(s.UserId)
+		if err != nil {
+			log.Error().Err(err).Int64("user_id", s.UserId).Msg("cannot read digest")
+			continue
+		}
+		if len(files) == 0 {
+			continue
+		}
+		for _, chunk := range AlbumChunks(files) {
+			if len(chunk) == 1 {
+				_, err = g.tg.SendImage("", s.UserId, &Image{FileId: chunk[0]}, "", nil)
+			} else {
+				err = g.tg.SendAlbum(s.UserId, chunk)
+			}
+			if err == nil {
+				// the delivered photos are not sent again if the next chunk fails
+				err = g.d.DeleteDigest(s.UserId, chunk)
+			}
+			if err != nil {
+				break
+			}
+		}
+		g.checkSubscriberError(s.UserId, err)
+	}
+}
+
+// checkSubscriberError logs the error, users who blocked the Bot are unsubscribed.
+func (g *Gorobei) checkSubscriberError(userId int64, err error) {
+	if err == nil {
+		return
+	}
+	if IsForbidden(err) {
+		log.Info().Err(err).Int64("user_id", userId).Msg("user has blocked the bot, removing subscriptions")
+		err = g.removeSubscriber(userId)
+	}
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", userId).Msg("cannot deliver image to the subscriber")
+	}
+}
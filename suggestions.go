@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mymmrac/telego"
+	"github.com/phuslu/log"
+	"gorobei/utils"
+	"strconv"
+	"strings"
+)
+
+const (
+	// CmdReview sends the suggestions waiting for review to the admin
+	CmdReview = "/review"
+	// CallbackApprove is the data of the approve button: 'approve:<user_id>:<message_id>:<chat_id>'
+	CallbackApprove = "approve:"
+	// CallbackReject is the data of the reject button: 'reject:<user_id>:<message_id>'
+	CallbackReject = "reject:"
+)
+
+// handleSuggestion puts the image sent by the user to the review queue. It returns false if the message has no image
+// or suggestions are disabled.
+func (g *Gorobei) handleSuggestion(m *telego.Message) bool {
+	if m.Chat.Type != "private" || m.From == nil || g.config == nil || g.config.Suggestions == nil {
+		return false
+	}
+	sub := parseSubmission(m)
+	if sub == nil {
+		return false
+	}
+	reply := g.suggest(m, sub)
+	err := g.tg.SendMessageText("", m.Chat.ID, reply)
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", m.Chat.ID).Msg("cannot reply to the user")
+	}
+	return true
+}
+
+func (g *Gorobei) suggest(m *telego.Message, sub *Submission) string {
+	id, err := g.d.ReadUserId(strings.ToLower(m.From.Username))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Error().Err(err).Int64("user_id", m.From.ID).Msg("cannot read user")
+		return "Sorry, something went wrong."
+	}
+	if m.From.Username == "" || id != m.From.ID {
+		return "Sorry, only registered users can suggest images. Set a username and try again."
+	}
+	s := &Suggestion{
+		UserId:     m.From.ID,
+		Username:   m.From.Username,
+		MessageId:  m.MessageID,
+		Submission: *sub,
+		CreatedAt:  g.clock.Now(),
+	}
+	// the quota is not taken if the suggestion is not stored
+	quota := g.config.Suggestions.DailyQuota
+	ok, err := g.d.SuggestWithQuota(s, g.clock.Now().Format("2006-01-02"), quota)
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", m.From.ID).Msg("cannot store suggestion")
+		return "Sorry, something went wrong."
+	}
+	if !ok {
+		return fmt.Sprintf("You can suggest %v images per day. Please try again tomorrow.", quota)
+	}
+	err = g.notify(NotifySuggestion, SeverityInfo, func(id int64) error {
+		return g.sendForReview(id, s)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("cannot send suggestion for review")
+	}
+	return "Thank you! The image will be posted after review."
+}
+
+// sendForReview forwards the suggested image to the admin along with the review buttons.
+func (g *Gorobei) sendForReview(adminId int64, s *Suggestion) error {
+	err := g.tg.ForwardMessage(adminId, s.UserId, s.MessageId)
+	if err != nil {
+		return err
+	}
+	var rows [][]telego.InlineKeyboardButton
+	for _, d := range g.submissionDestinations() {
+		rows = append(rows, []telego.InlineKeyboardButton{{
+			Text:         "Post to " + d.Chat,
+			CallbackData: fmt.Sprintf("%s%v:%v:%v", CallbackApprove, s.UserId, s.MessageId, d.chatId),
+		}})
+	}
+	rows = append(rows, []telego.InlineKeyboardButton{{
+		Text:         "Reject",
+		CallbackData: fmt.Sprintf("%s%v:%v", CallbackReject, s.UserId, s.MessageId),
+	}})
+	text := fmt.Sprintf("Suggested by @%s", s.Username)
+	return g.tg.SendKeyboard(adminId, text, &telego.InlineKeyboardMarkup{InlineKeyboard: rows})
+}
+
+// sendReviewQueue sends all the suggestions waiting for review to the admin.
+func (g *Gorobei) sendReviewQueue(adminId int64) string {
+	queue, err := g.d.ReadSuggestions()
+	if err != nil {
+		log.Error().Err(err).Msg("cannot read suggestions")
+		return "Sorry, something went wrong."
+	}
+	for _, s := range queue {
+		err = g.sendForReview(adminId, s)
+		if err != nil {
+			log.Error().Err(err).Int64("user_id", s.UserId).Int("message_id", s.MessageId).Msg("cannot send suggestion for review")
+		}
+	}
+	return fmt.Sprintf("%v suggestions are waiting for review.", len(queue))
+}
+
+// handleReviewCallback approves or rejects the suggestion.
+func (g *Gorobei) handleReviewCallback(q *telego.CallbackQuery) {
+	answer := g.review(q)
+	err := g.tg.AnswerCallback(q.ID, answer)
+	if err != nil {
+		log.Error().Err(err).Msg("cannot answer callback query")
+	}
+}
+
+func (g *Gorobei) review(q *telego.CallbackQuery) string {
+	if !g.isAdmin(q.From.ID) {
+		return "Only admins can review suggestions."
+	}
+	approve := strings.HasPrefix(q.Data, CallbackApprove)
+	args := strings.Split(strings.TrimPrefix(strings.TrimPrefix(q.Data, CallbackApprove), CallbackReject), ":")
+	if (approve && len(args) != 3) || (!approve && len(args) != 2) {
+		log.Error().Str("data", q.Data).Msg("unexpected callback data")
+		return "Sorry, something went wrong."
+	}
+	ids := make([]int64, len(args))
+	for i, a := range args {
+		var err error
+		ids[i], err = strconv.ParseInt(a, 10, 64)
+		if err != nil {
+			log.Error().Err(err).Str("data", q.Data).Msg("unexpected callback data")
+			return "Sorry, something went wrong."
+		}
+	}
+	var dest *Destination
+	if approve {
+		for _, d := range g.submissionDestinations() {
+			if d.chatId == ids[2] {
+				dest = d
+			}
+		}
+		if dest == nil {
+			return "The chat is not configured anymore."
+		}
+	}
+	s, err := g.d.TakeSuggestion(ids[0], int(ids[1]))
+	if errors.Is(err, ErrNotFound) {
+		return "The suggestion has been reviewed already."
+	}
+	if err != nil {
+		log.Error().Err(err).Str("data", q.Data).Msg("cannot read suggestion")
+		return "Sorry, something went wrong."
+	}
+	if !approve {
+		g.tellSubmitter(s, "Sorry, the image you suggested has been declined.")
+		return "Rejected."
+	}
+
+	caption := s.Caption
+	if g.config.Suggestions.Credit {
+		if caption != "" {
+			caption += "\n\n"
+		}
+		caption += "Suggested by @" + s.Username
+	}
+	err = g.postImage(s.Src(), "", caption, []*Destination{dest}, func(src string) (*Image, error) {
+		return g.submissionImage(&s.Submission)
+	})
+	switch {
+	case errors.Is(err, ErrImageAlreadyProcessed):
+		g.tellSubmitter(s, "Thank you! The image you suggested has been posted already.")
+		return fmt.Sprintf("The image has been posted to %s already.", dest.Chat)
+	case err != nil:
+		log.Error().Err(err).Str("src", s.Src()).Str("chat", dest.Chat).Msg("cannot post suggestion")
+		// keep the suggestion in the queue, so it can be approved again
+		if err2 := g.d.StoreSuggestion(s); err2 != nil {
+			log.Error().Err(err2).Msg("cannot store suggestion")
+		}
+		if errors.Is(err, ErrChatsPaused) {
+			return fmt.Sprintf("Posting to %s is paused.", dest.Chat)
+		}
+		return utils.FirstN("Cannot post the image: "+err.Error(), maxCallbackAnswer)
+	}
+	g.tellSubmitter(s, fmt.Sprintf("Thank you! The image you suggested has been posted to %s.", dest.Chat))
+	return fmt.Sprintf("Posted to %s.", dest.Chat)
+}
+
+func (g *Gorobei) tellSubmitter(s *Suggestion, text string) {
+	err := g.tg.SendMessageText("", s.UserId, text)
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", s.UserId).Msg("cannot tell the review outcome")
+	}
+}
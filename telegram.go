@@ -4,26 +4,84 @@ import (
 	"errors"
 	"fmt"
 	"github.com/mymmrac/telego"
+	"github.com/mymmrac/telego/api"
 	"github.com/phuslu/log"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpproxy"
 	"gorobei/limiter"
 	"gorobei/utils"
+	"io"
+	"net/url"
 	"os"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 type (
 	Telegram interface {
-		SendImage(user string, userId int64, image string, caption string) error
+		SendImage(user string, userId int64, image *Image, caption string, opts *PostOptions) (*SentMessage, error)
 		SendMessageMarkdown(user string, userId int64, message string) error
 		SendMessageText(user string, userId int64, message string) error
+		SendMessage(user string, userId int64, message *MessageBuilder) error
 		DoUpdates() error
 		ChatInfo(string) (*telego.Chat, error)
+		SetRateLimit(userId int64, maxRpm float64, maxRps float64)
+		DeleteMessage(chatId int64, messageId int) error
+		// EditCaption replaces the caption and the inline keyboard, nil markup removes the keyboard
+		EditCaption(chatId int64, messageId int, caption string, markup *telego.InlineKeyboardMarkup) error
+		SetReactions(chatId int64, messageId int, up int, down int, current *telego.InlineKeyboardMarkup) error
+		AnswerCallback(queryId string, text string) error
+		Listen(stop <-chan struct{}, handler func(u *telego.Update)) error
+		SendAlbum(userId int64, fileIds []string) error
+		SendKeyboard(userId int64, text string, markup *telego.InlineKeyboardMarkup) error
+		DownloadFile(fileId string) (string, error)
+		ForwardMessage(userId int64, fromChatId int64, messageId int) error
+	}
+	// Image is either a local file or a photo already uploaded to Telegram
+	Image struct {
+		Path   string
+		FileId string
+	}
+	// SentMessage identifies the message posted to a chat. FileId is set for photos.
+	SentMessage struct {
+		ChatId    int64
+		MessageId int
+		FileId    string
+	}
+	// PostOptions are additional parameters of the images posted to a chat
+	PostOptions struct {
+		// ThreadId is the forum topic of the supergroup
+		ThreadId            int  `json:"message_thread_id,omitempty"`
+		DisableNotification bool `json:"disable_notification,omitempty"`
+		// QuietHours are the hours the posts are sent silently
+		QuietHours     *QuietHours `json:"quiet_hours,omitempty"`
+		ProtectContent bool        `json:"protect_content,omitempty"`
+		HasSpoiler     bool        `json:"has_spoiler,omitempty"`
+		// Reactions adds inline like/dislike buttons to the post
+		Reactions bool `json:"reactions,omitempty"`
+		// SourceButton is the text of the inline button linking to the source page, no button if empty
+		SourceButton string `json:"source_button,omitempty"`
+		sourceUrl    string
 	}
 	telegramImpl struct {
 		Bot      *telego.Bot
 		store    UsersStore
+		mu       sync.Mutex
 		limiters map[string]*limiter.RateLimiter // key = channel_name or string(chat_id)
+		global   *limiter.WindowLimiter
+		sleep    func(time.Duration)
+		// limiterStore keeps limiter states between process runs, if set
+		limiterStore   limiter.StateStore
+		pollingTimeout int
+		// caller and apiUrl are used for the methods telego doesn't support
+		caller api.Caller
+		apiUrl string
+		client *fasthttp.Client
 	}
 )
 
@@ -51,8 +109,64 @@ runtime.goexit
 ³³³`)
 )
 
-func NewTelegram(token string, store UsersStore) (Telegram, error) {
-	bot, err := telego.NewBot(token)
+// TelegramConfig describes the connection to Bot API.
+// Client overrides Proxy and Timeout, it is used by tests to talk to a fake server.
+type TelegramConfig struct {
+	Token   string
+	ApiUrl  string        // self-hosted Bot API server, https://api.telegram.org is used by default
+	Proxy   string        // socks5://[user:password@]host:port or http://[user:password@]host:port
+	Timeout time.Duration // request timeout, zero means no timeout
+	Client  *fasthttp.Client
+}
+
+func (c *TelegramConfig) httpClient() (*fasthttp.Client, error) {
+	if c.Client != nil {
+		return c.Client, nil
+	}
+	client := &fasthttp.Client{
+		ReadTimeout:  c.Timeout,
+		WriteTimeout: c.Timeout,
+	}
+	if c.Proxy == "" {
+		return client, nil
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		client.Dial = fasthttpproxy.FasthttpSocksDialer(c.Proxy)
+	case "http":
+		client.Dial = fasthttpproxy.FasthttpHTTPDialerTimeout(strings.TrimPrefix(c.Proxy, "http://"), c.Timeout)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: '%s'", u.Scheme)
+	}
+	return client, nil
+}
+
+// pollingTimeout returns the long polling timeout (in seconds) which fits into the request timeout.
+func (c *TelegramConfig) pollingTimeout() int {
+	t := longPollingTimeout
+	if c.Timeout > 0 && c.Timeout < time.Duration(t+5)*time.Second {
+		t = int(c.Timeout/time.Second) - 5
+		if t < 0 {
+			t = 0
+		}
+	}
+	return t
+}
+
+func NewTelegram(config *TelegramConfig, store UsersStore, limiterStore limiter.StateStore) (Telegram, error) {
+	client, err := config.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	opts := []telego.BotOption{telego.FastHTTPClient(client)}
+	if config.ApiUrl != "" {
+		opts = append(opts, telego.SetAPIServer(strings.TrimSuffix(config.ApiUrl, "/")))
+	}
+	bot, err := telego.NewBot(config.Token, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -60,32 +174,321 @@ func NewTelegram(token string, store UsersStore) (Telegram, error) {
 		Bot:      bot,
 		store:    store,
 		limiters: make(map[string]*limiter.RateLimiter),
+		global:   limiter.NewWindowLimiter(limiter.MaxBotRatePerSecond, time.Second),
+		sleep:    time.Sleep,
+
+		limiterStore:   limiterStore,
+		pollingTimeout: config.pollingTimeout(),
+		caller:         api.FasthttpAPICaller{Client: client},
+		apiUrl:         defaultApiUrl,
+		client:         client,
+	}
+	if config.ApiUrl != "" {
+		tg.apiUrl = strings.TrimSuffix(config.ApiUrl, "/")
 	}
 	return &tg, nil
 }
 
-func (tg *telegramImpl) SendImage(user string, userId int64, image string, caption string) error {
+func (tg *telegramImpl) SendImage(user string, userId int64, image *Image, caption string, opts *PostOptions) (*SentMessage, error) {
 	chatId, err := tg.constructChatId(user, userId)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	tg.getLimiter(chatId).TikTak()
+	// the rest of the long caption is sent as a separate message
+	var rest string
+	if utils.Utf16Len(caption) > utils.MaxCaptionLength {
+		parts := utils.SplitText(caption, utils.MaxCaptionLength)
+		caption = parts[0]
+		rest = strings.Join(parts[1:], "\n")
+	}
 
-	f, err := os.Open(image)
+	params := &sendPhotoParams{
+		ChatID:  *chatId,
+		Caption: caption,
+	}
+	files := make(map[string]api.NamedReader)
+	if image.FileId != "" {
+		// no need to upload the file again
+		params.Photo = image.FileId
+	} else {
+		f, err := os.Open(image.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		files["photo"] = f
+	}
+	if opts != nil {
+		params.MessageThreadID = opts.ThreadId
+		params.DisableNotification = opts.DisableNotification
+		params.ProtectContent = opts.ProtectContent
+		params.HasSpoiler = opts.HasSpoiler
+		params.ReplyMarkup = postMarkup(opts)
+	}
+	var msg telego.Message
+	err = tg.withRetry(chatId, func() error {
+		if f, ok := files["photo"].(*os.File); ok {
+			// rewind the file in case of retry
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		return tg.callApi("sendPhoto", params, files, &msg)
+	})
 	if err != nil {
+		return nil, err
+	}
+	sent := &SentMessage{ChatId: msg.Chat.ID, MessageId: msg.MessageID}
+	if len(msg.Photo) > 0 {
+		// the last one is the largest size
+		sent.FileId = msg.Photo[len(msg.Photo)-1].FileID
+	}
+	if rest != "" {
+		// the photo is posted already, so the error is just logged
+		err = tg.SendMessageText("", msg.Chat.ID, rest)
+		if err != nil {
+			log.Error().Err(err).Int64("chat_id", msg.Chat.ID).Msg("cannot send the rest of the caption")
+		}
+	}
+	return sent, nil
+}
+
+func (tg *telegramImpl) DeleteMessage(chatId int64, messageId int) error {
+	params := &telego.DeleteMessageParams{
+		ChatID:    telego.ChatID{ID: chatId},
+		MessageID: messageId,
+	}
+	return tg.withRetry(&params.ChatID, func() error {
+		return tg.Bot.DeleteMessage(params)
+	})
+}
+
+func (tg *telegramImpl) EditCaption(chatId int64, messageId int, caption string, markup *telego.InlineKeyboardMarkup) error {
+	params := &telego.EditMessageCaptionParams{
+		ChatID:      telego.ChatID{ID: chatId},
+		MessageID:   messageId,
+		Caption:     caption,
+		ReplyMarkup: markup,
+	}
+	return tg.withRetry(&params.ChatID, func() error {
+		_, err := tg.Bot.EditMessageCaption(params)
 		return err
+	})
+}
+
+// maxAlbumSize is the maximum number of photos in a media group
+const maxAlbumSize = 10
+
+// AlbumChunks splits the photos into media groups of balanced size, so no group has a single photo
+// unless there is a single photo at all. Telegram accepts groups of 2 to maxAlbumSize photos.
+func AlbumChunks(fileIds []string) [][]string {
+	var res [][]string
+	n := (len(fileIds) + maxAlbumSize - 1) / maxAlbumSize
+	for ; n > 0; n-- {
+		size := (len(fileIds) + n - 1) / n
+		res = append(res, fileIds[:size])
+		fileIds = fileIds[size:]
 	}
+	return res
+}
 
-	params := &telego.SendPhotoParams{
-		ChatID:  *chatId,
-		Photo:   telego.InputFile{File: f},
-		Caption: caption,
+// SendAlbum sends uploaded photos as a media group.
+func (tg *telegramImpl) SendAlbum(userId int64, fileIds []string) error {
+	if len(fileIds) < 2 || len(fileIds) > maxAlbumSize {
+		return fmt.Errorf("album must have 2 to %v photos, got %v", maxAlbumSize, len(fileIds))
+	}
+	chatId := &telego.ChatID{ID: userId}
+	params := &telego.SendMediaGroupParams{ChatID: *chatId}
+	for _, id := range fileIds {
+		params.Media = append(params.Media, &telego.InputMediaPhoto{
+			Type:  "photo",
+			Media: telego.InputFile{FileID: id},
+		})
+	}
+	return tg.withRetry(chatId, func() error {
+		_, err := tg.Bot.SendMediaGroup(params)
+		return err
+	})
+}
+
+type (
+	// ChatMigratedError is returned when the group has been upgraded to a supergroup
+	ChatMigratedError struct {
+		From int64
+		To   int64
+		err  error
+	}
+	// ChatUnavailableError is returned when the Bot was removed from the chat or cannot post there
+	ChatUnavailableError struct {
+		ChatId int64
+		Reason string
+		err    error
+	}
+)
+
+func (e *ChatMigratedError) Error() string {
+	return fmt.Sprintf("chat %v has been migrated to %v: %v", e.From, e.To, e.err)
+}
+
+func (e *ChatMigratedError) Unwrap() error {
+	return e.err
+}
+
+func (e *ChatUnavailableError) Error() string {
+	return fmt.Sprintf("chat %v is unavailable (%s): %v", e.ChatId, e.Reason, e.err)
+}
+
+func (e *ChatUnavailableError) Unwrap() error {
+	return e.err
+}
+
+var chatUnavailableReasons = []string{
+	"bot was kicked",
+	"bot is not a member",
+	"not enough rights",
+	"have no rights to send",
+	"chat_write_forbidden",
+	"chat not found",
+}
+
+// classifyChatError converts API errors caused by the chat state to ChatMigratedError and ChatUnavailableError.
+func classifyChatError(chatId *telego.ChatID, err error) error {
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	if apiErr.Parameters != nil && apiErr.Parameters.MigrateToChatID != 0 {
+		return &ChatMigratedError{From: chatId.ID, To: apiErr.Parameters.MigrateToChatID, err: err}
+	}
+	desc := strings.ToLower(apiErr.Description)
+	for _, r := range chatUnavailableReasons {
+		if strings.Contains(desc, r) {
+			return &ChatUnavailableError{ChatId: chatId.ID, Reason: r, err: err}
+		}
 	}
-	_, err = tg.Bot.SendPhoto(params)
 	return err
 }
 
+// IsForbidden checks if the Bot was blocked by the user or cannot post to the chat.
+func IsForbidden(err error) bool {
+	var apiErr *api.Error
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == 403
+}
+
+const (
+	CallbackVoteUp   = "vote:up"
+	CallbackVoteDown = "vote:down"
+)
+
+func reactionsMarkup(up int, down int) *telego.InlineKeyboardMarkup {
+	return &telego.InlineKeyboardMarkup{InlineKeyboard: [][]telego.InlineKeyboardButton{reactionsRow(up, down)}}
+}
+
+func reactionsRow(up int, down int) []telego.InlineKeyboardButton {
+	return []telego.InlineKeyboardButton{
+		{Text: fmt.Sprintf("👍 %v", up), CallbackData: CallbackVoteUp},
+		{Text: fmt.Sprintf("👎 %v", down), CallbackData: CallbackVoteDown},
+	}
+}
+
+// withReactions replaces the reactions row of the keyboard.
+func withReactions(current *telego.InlineKeyboardMarkup, up int, down int) *telego.InlineKeyboardMarkup {
+	if current == nil {
+		return reactionsMarkup(up, down)
+	}
+	res := &telego.InlineKeyboardMarkup{}
+	for _, row := range current.InlineKeyboard {
+		if len(row) > 0 && (row[0].CallbackData == CallbackVoteUp || row[0].CallbackData == CallbackVoteDown) {
+			row = reactionsRow(up, down)
+		}
+		res.InlineKeyboard = append(res.InlineKeyboard, row)
+	}
+	return res
+}
+
+// postMarkup returns the inline keyboard of the new post or nil if there are no buttons.
+func postMarkup(opts *PostOptions) *telego.InlineKeyboardMarkup {
+	var rows [][]telego.InlineKeyboardButton
+	if opts.Reactions {
+		rows = append(rows, reactionsRow(0, 0))
+	}
+	if opts.SourceButton != "" && opts.sourceUrl != "" {
+		rows = append(rows, []telego.InlineKeyboardButton{{Text: opts.SourceButton, URL: opts.sourceUrl}})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return &telego.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// SetReactions updates the counters shown on the reaction buttons of the message.
+// The rest of the current keyboard is kept.
+func (tg *telegramImpl) SetReactions(chatId int64, messageId int, up int, down int, current *telego.InlineKeyboardMarkup) error {
+	params := &telego.EditMessageReplyMarkupParams{
+		ChatID:      telego.ChatID{ID: chatId},
+		MessageID:   messageId,
+		ReplyMarkup: withReactions(current, up, down),
+	}
+	return tg.withRetry(&params.ChatID, func() error {
+		_, err := tg.Bot.EditMessageReplyMarkup(params)
+		return err
+	})
+}
+
+// SendKeyboard sends the text message with the inline keyboard.
+func (tg *telegramImpl) SendKeyboard(userId int64, text string, markup *telego.InlineKeyboardMarkup) error {
+	return tg.sendMessage("", userId, &telego.SendMessageParams{Text: text, ReplyMarkup: markup})
+}
+
+func (tg *telegramImpl) ForwardMessage(userId int64, fromChatId int64, messageId int) error {
+	params := &telego.ForwardMessageParams{
+		ChatID:     telego.ChatID{ID: userId},
+		FromChatID: telego.ChatID{ID: fromChatId},
+		MessageID:  messageId,
+	}
+	return tg.withRetry(&params.ChatID, func() error {
+		_, err := tg.Bot.ForwardMessage(params)
+		return err
+	})
+}
+
+// DownloadFile downloads the file sent to the Bot to a temporary file and returns its path.
+func (tg *telegramImpl) DownloadFile(fileId string) (string, error) {
+	f, err := tg.Bot.GetFile(&telego.GetFileParams{FileID: fileId})
+	if err != nil {
+		return "", err
+	}
+	if f.FilePath == "" {
+		return "", fmt.Errorf("file %s cannot be downloaded", fileId)
+	}
+	status, body, err := tg.client.Get(nil, tg.apiUrl+"/file/bot"+tg.Bot.Token()+"/"+f.FilePath)
+	if err != nil {
+		return "", err
+	}
+	if status != fasthttp.StatusOK {
+		return "", fmt.Errorf("cannot download file %s: http error %v", fileId, status)
+	}
+	tf, err := os.CreateTemp("", "*"+path.Ext(f.FilePath))
+	if err != nil {
+		return "", err
+	}
+	defer tf.Close()
+	_, err = tf.Write(body)
+	if err != nil {
+		os.Remove(tf.Name())
+		return "", err
+	}
+	return tf.Name(), nil
+}
+
+func (tg *telegramImpl) AnswerCallback(queryId string, text string) error {
+	return tg.Bot.AnswerCallbackQuery(&telego.AnswerCallbackQueryParams{
+		CallbackQueryID: queryId,
+		Text:            text,
+	})
+}
+
 func (tg *telegramImpl) getLimiter(id *telego.ChatID) *limiter.RateLimiter {
 	var key string
 	if id.ID != 0 {
@@ -97,64 +500,241 @@ func (tg *telegramImpl) getLimiter(id *telego.ChatID) *limiter.RateLimiter {
 		log.Error().Msg("cannot get limiter: empty chat id")
 		return nil
 	}
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
 	l, ok := tg.limiters[key]
 	if !ok {
-		l = limiter.NewLimiter()
+		l = tg.persist(limiter.NewLimiter(), key)
 		tg.limiters[key] = l
 	}
 	return l
 }
 
+// SetRateLimit replaces the default limiter of the chat with the limiter having custom rates.
+func (tg *telegramImpl) SetRateLimit(userId int64, maxRpm float64, maxRps float64) {
+	key := fmt.Sprintf("%v", userId)
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	tg.limiters[key] = tg.persist(limiter.NewLimiterWithRates(maxRpm, maxRps), key)
+}
+
+func (tg *telegramImpl) persist(l *limiter.RateLimiter, key string) *limiter.RateLimiter {
+	if tg.limiterStore != nil {
+		l.Persist(tg.limiterStore, key)
+	}
+	return l
+}
+
+// SendMessageMarkdown escapes the message and sends it, long messages are split into several ones.
 func (tg *telegramImpl) SendMessageMarkdown(user string, userId int64, message string) error {
 	message = EscapeMarkdown(message)
-	return tg.sendMessage(user, userId, message, "MarkdownV2")
+	for _, m := range utils.SplitMarkdown(message, utils.MaxMessageLength) {
+		err := tg.sendMessage(user, userId, &telego.SendMessageParams{Text: m, ParseMode: "MarkdownV2"})
+		if offset, ok := parseEntitiesError(err); ok {
+			// the message is still delivered, but EscapeMarkdown needs fixing
+			log.Error().Err(err).Int("offset", offset).Str("near", nearOffset(m, offset)).
+				Msg("cannot parse markdown, sending as plain text")
+			err = tg.sendMessage(user, userId, &telego.SendMessageParams{Text: StripMarkdown(m)})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+var reByteOffset = regexp.MustCompile(`byte offset (\d+)`)
+
+// parseEntitiesError checks if err is the markdown parse error and returns the byte offset of the error
+// or -1 if Telegram doesn't report it.
+func parseEntitiesError(err error) (int, bool) {
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode != 400 || !strings.Contains(apiErr.Description, "can't parse entities") {
+		return 0, false
+	}
+	m := reByteOffset.FindStringSubmatch(apiErr.Description)
+	if m == nil {
+		return -1, true
+	}
+	offset, _ := strconv.Atoi(m[1])
+	return offset, true
+}
+
+// nearOffset returns the part of the message around the byte offset.
+func nearOffset(s string, offset int) string {
+	if offset < 0 || offset > len(s) {
+		return ""
+	}
+	from, to := offset-20, offset+20
+	if from < 0 {
+		from = 0
+	}
+	if to > len(s) {
+		to = len(s)
+	}
+	return strings.ToValidUTF8(s[from:to], "")
+}
+
+// SendMessageText sends the message, long messages are split into several ones.
 func (tg *telegramImpl) SendMessageText(user string, userId int64, message string) error {
-	return tg.sendMessage(user, userId, message, "")
+	for _, m := range utils.SplitText(message, utils.MaxMessageLength) {
+		err := tg.sendMessage(user, userId, &telego.SendMessageParams{Text: m})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (tg *telegramImpl) sendMessage(user string, userId int64, message string, mode string) error {
+// SendMessage sends the message with entities, so it doesn't depend on markdown escaping.
+func (tg *telegramImpl) SendMessage(user string, userId int64, message *MessageBuilder) error {
+	for _, m := range message.Split(utils.MaxMessageLength) {
+		err := tg.sendMessage(user, userId, &telego.SendMessageParams{Text: m.Text(), Entities: m.Entities()})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tg *telegramImpl) sendMessage(user string, userId int64, params *telego.SendMessageParams) error {
 	chatId, err := tg.constructChatId(user, userId)
 	if err != nil {
 		return err
 	}
+	params.ChatID = *chatId
+	return tg.withRetry(chatId, func() error {
+		_, err := tg.Bot.SendMessage(params)
+		return err
+	})
+}
+
+const maxFloodRetries = 3
 
-	tg.getLimiter(chatId).TikTak()
+var reRetryAfter = regexp.MustCompile(`(?i)retry after (\d+)`)
 
-	params := &telego.SendMessageParams{
-		ChatID:    *chatId,
-		Text:      message,
-		ParseMode: mode,
+// retryAfter returns the delay requested by Telegram flood control error, 0 if err isn't such an error.
+func retryAfter(err error) time.Duration {
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) {
+		return 0
+	}
+	if apiErr.Parameters != nil && apiErr.Parameters.RetryAfter > 0 {
+		return time.Duration(apiErr.Parameters.RetryAfter) * time.Second
+	}
+	if apiErr.ErrorCode == 429 {
+		// "Too Many Requests: retry after N"
+		if m := reRetryAfter.FindStringSubmatch(apiErr.Description); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			return time.Duration(n) * time.Second
+		}
+		return time.Second
+	}
+	return 0
+}
+
+// withRetry waits for both the chat and the bot-wide limiters and calls f.
+// Flood control errors are retried after the delay requested by Telegram.
+func (tg *telegramImpl) withRetry(chatId *telego.ChatID, f func() error) error {
+	var err error
+	for i := 0; ; i++ {
+		tg.getLimiter(chatId).TikTak()
+		tg.global.TikTak()
+		err = f()
+		delay := retryAfter(err)
+		if delay == 0 || i >= maxFloodRetries {
+			return classifyChatError(chatId, err)
+		}
+		log.Warn().Err(err).Dur("retry_after", delay).Int("attempt", i+1).Msg("flood control exceeded, waiting")
+		tg.sleep(delay)
 	}
-	_, err = tg.Bot.SendMessage(params)
-	return err
 }
 
 func (tg *telegramImpl) constructChatId(user string, userId int64) (*telego.ChatID, error) {
 	var chatId telego.ChatID
-	user = strings.ToLower(user)
-
-	switch {
-	case user == "":
+	if user == "" {
 		chatId.ID = userId
-	case user[0] == '@':
-		//channel or group name
-		chatId.Username = user
-	default:
-		//ordinary user
-		id, err := tg.store.ReadUserId(user)
+		return &chatId, nil
+	}
+	id, name, err := ParseChat(user)
+	if err != nil {
+		return nil, err
+	}
+	if id != 0 {
+		chatId.ID = id
+		return &chatId, nil
+	}
+	if name[0] == '@' {
+		// channel or group name, it may be a user name as well
+		id, err = tg.store.ReadChatId(name)
 		if errors.Is(err, ErrNotFound) {
-			return nil, fmt.Errorf("cannot find ID of the user '%s'. To enable Bot to send messages to a user, the user should send '/start' message to the Bot first", user)
+			id, err = tg.store.ReadUserId(name[1:])
+		}
+		if errors.Is(err, ErrNotFound) {
+			chatId.Username = name
+			return &chatId, nil
 		}
 		if err != nil {
 			return nil, err
 		}
 		chatId.ID = id
+		return &chatId, nil
+	}
+	//ordinary user
+	id, err = tg.store.ReadUserId(name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("cannot find ID of the user '%s'. To enable Bot to send messages to a user, the user should send '/start' message to the Bot first", user)
+	}
+	if err != nil {
+		return nil, err
 	}
+	chatId.ID = id
 	return &chatId, nil
 }
 
+// ParseChat parses the chat reference: numeric ID (e.g. -1001234567890), '@name', 't.me/name' link, 't.me/c/ID'
+// link of a private supergroup or channel post or the name of the user who has sent a message to the Bot. Either the ID or the lower case name is returned,
+// names of channels and groups start with '@'.
+func ParseChat(s string) (int64, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, "", errors.New("empty chat")
+	}
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return id, "", nil
+	}
+	link := strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+	for _, host := range []string{"t.me/", "telegram.me/"} {
+		if !strings.HasPrefix(link, host) {
+			continue
+		}
+		name := strings.TrimPrefix(link, host)
+		if strings.HasPrefix(name, "c/") {
+			// the private post links hold the chat ID without -100 prefix
+			id := strings.TrimPrefix(name, "c/")
+			if i := strings.IndexAny(id, "/?#"); i >= 0 {
+				id = id[:i]
+			}
+			n, err := strconv.ParseInt("-100"+id, 10, 64)
+			if err != nil || id == "" {
+				return 0, "", fmt.Errorf("cannot parse chat ID of the link '%s'", s)
+			}
+			return n, "", nil
+		}
+		if i := strings.IndexAny(name, "/?#"); i >= 0 {
+			name = name[:i]
+		}
+		if name == "" || name[0] == '+' || name == "joinchat" {
+			return 0, "", fmt.Errorf("cannot resolve invite link '%s', use the chat ID instead", s)
+		}
+		return 0, "@" + strings.ToLower(name), nil
+	}
+	if s == "@" {
+		return 0, "", errors.New("empty chat name")
+	}
+	return 0, strings.ToLower(s), nil
+}
+
 // https://api.telegram.org/bot<TOKEN>/getUpdates
 //
 func (tg *telegramImpl) DoUpdates() error {
@@ -168,27 +748,75 @@ func (tg *telegramImpl) DoUpdates() error {
 		return err
 	}
 
-	for _, u := range updates {
-		if u.Message != nil {
-			uname := strings.ToLower(u.Message.Chat.Username)
-			_, err = tg.store.ReadUserId(uname)
-			if errors.Is(err, ErrNotFound) {
-				err = tg.store.StoreUserId(uname, u.Message.Chat.ID)
-				if err != nil {
-					return err
-				}
+	for i := range updates {
+		err = tg.storeUser(&updates[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeUser remembers the user who has sent a private message to the Bot.
+// Users without username are skipped, they cannot be referenced by name.
+func (tg *telegramImpl) storeUser(u *telego.Update) error {
+	if u.Message == nil || u.Message.Chat.Type != "private" || u.Message.Chat.Username == "" {
+		return nil
+	}
+	c := u.Message.Chat
+	return tg.store.StoreUser(&User{
+		Id:        c.ID,
+		Username:  c.Username,
+		FirstName: c.FirstName,
+		LastName:  c.LastName,
+		LastSeen:  time.Unix(int64(u.Message.Date), 0),
+	})
+}
+
+const longPollingTimeout = 30 // seconds
+
+// Listen receives updates using long polling and passes them to the handler until stop is closed.
+func (tg *telegramImpl) Listen(stop <-chan struct{}, handler func(u *telego.Update)) error {
+	params := &telego.GetUpdatesParams{
+		Timeout:        tg.pollingTimeout,
+		AllowedUpdates: []string{"message", "callback_query"},
+	}
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		updates, err := tg.Bot.GetUpdates(params)
+		if err != nil {
+			if delay := retryAfter(err); delay > 0 {
+				tg.sleep(delay)
 				continue
 			}
+			log.Error().Err(err).Msg("cannot get updates")
+			tg.sleep(5 * time.Second)
+			continue
+		}
+		for i := range updates {
+			u := &updates[i]
+			// confirm the update
+			params.Offset = u.UpdateID + 1
+			err = tg.storeUser(u)
 			if err != nil {
-				return err
+				log.Error().Err(err).Msg("cannot store user")
 			}
+			handler(u)
 		}
 	}
-	return nil
 }
 
+// ChatInfo returns the chat by any reference ParseChat accepts.
 func (tg *telegramImpl) ChatInfo(name string) (*telego.Chat, error) {
-	params := &telego.GetChatParams{ChatID: telego.ChatID{Username: name}}
+	id, name, err := ParseChat(name)
+	if err != nil {
+		return nil, err
+	}
+	params := &telego.GetChatParams{ChatID: telego.ChatID{ID: id, Username: name}}
 	return tg.Bot.GetChat(params)
 }
 
@@ -230,6 +858,53 @@ func replaceAllChars(i []byte) []byte {
 	return []byte(reOthers.ReplaceAllString(string(i), `\$0`))
 }
 
+// StripMarkdown converts an escaped MarkdownV2 message to plain text: markup characters are removed,
+// links are replaced with "text (url)" and escape sequences are unescaped.
+func StripMarkdown(msg string) string {
+	var (
+		b                             strings.Builder
+		escaped, inPre, inLink, inUrl bool
+		lineStart                     = true
+	)
+	for i := 0; i < len(msg); {
+		r, size := utf8.DecodeRuneInString(msg[i:])
+		switch {
+		case escaped:
+			escaped = false
+			b.WriteRune(r)
+		case r == '\\':
+			escaped = true
+		case lineStart && strings.HasPrefix(msg[i:], "```"):
+			// skip the fence line
+			inPre = !inPre
+			end := strings.IndexByte(msg[i:], '\n')
+			if end < 0 {
+				i = len(msg)
+				continue
+			}
+			i += end + 1
+			continue
+		case inPre:
+			b.WriteRune(r)
+		case r == '[':
+			inLink = true
+		case r == ']' && inLink && strings.HasPrefix(msg[i:], "]("):
+			inLink, inUrl = false, true
+			b.WriteString(" (")
+			size += 1
+		case r == ')' && inUrl:
+			inUrl = false
+			b.WriteRune(r)
+		case !inUrl && strings.ContainsRune("*_~|`", r):
+		default:
+			b.WriteRune(r)
+		}
+		lineStart = r == '\n' && !escaped
+		i += size
+	}
+	return b.String()
+}
+
 // EscapeMarkdown implements required message text escaping: https://core.telegram.org/bots/api#formatting-options.
 // Inside pre and code entities, all '`' and '\' characters must be escaped with a preceding '\' character.
 // Inside (...) part of inline link definition, all ')' and '\' must be escaped with a preceding '\' character.
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mymmrac/telego"
+	"github.com/mymmrac/telego/api"
+	"strings"
+)
+
+const defaultApiUrl = "https://api.telegram.org"
+
+// sendPhotoParams extends telego.SendPhotoParams with the parameters of the newer Bot API versions.
+// The photo is either uploaded as "photo" file or passed by file_id.
+type sendPhotoParams struct {
+	ChatID              telego.ChatID                `json:"chat_id"`
+	MessageThreadID     int                          `json:"message_thread_id,omitempty"`
+	Photo               string                       `json:"photo,omitempty"`
+	Caption             string                       `json:"caption,omitempty"`
+	HasSpoiler          bool                         `json:"has_spoiler,omitempty"`
+	DisableNotification bool                         `json:"disable_notification,omitempty"`
+	ProtectContent      bool                         `json:"protect_content,omitempty"`
+	ReplyMarkup         *telego.InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// callApi performs the request of the Bot API method. The errors are wrapped the same way telego does,
+// so retryAfter and other error checks work for both.
+func (tg *telegramImpl) callApi(method string, params interface{}, files map[string]api.NamedReader, result interface{}) error {
+	var (
+		data *api.RequestData
+		err  error
+	)
+	constructor := api.DefaultConstructor{}
+	if len(files) > 0 {
+		var fields map[string]string
+		fields, err = multipartFields(params)
+		if err != nil {
+			return err
+		}
+		data, err = constructor.MultipartRequest(fields, files)
+	} else {
+		data, err = constructor.JSONRequest(params)
+	}
+	if err != nil {
+		return fmt.Errorf("%s request: %w", method, err)
+	}
+	resp, err := tg.caller.Call(tg.apiUrl+"/bot"+tg.Bot.Token()+"/"+method, data)
+	if err != nil {
+		return fmt.Errorf("internal execution: %w", err)
+	}
+	if !resp.Ok {
+		return fmt.Errorf("api: %w", resp.Error)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		err = json.Unmarshal(resp.Result, result)
+		if err != nil {
+			return fmt.Errorf("unmarshal %s result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// multipartFields converts the parameters to form fields: strings are passed as is, the rest as json.
+func multipartFields(params interface{}) (map[string]string, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(raw))
+	for k, v := range raw {
+		s := string(v)
+		if strings.HasPrefix(s, `"`) {
+			err = json.Unmarshal(v, &s)
+			if err != nil {
+				return nil, err
+			}
+		}
+		fields[k] = s
+	}
+	return fields, nil
+}
@@ -1,9 +1,20 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mymmrac/telego"
+	"github.com/mymmrac/telego/api"
 	"github.com/stretchr/testify/require"
+	"gorobei/limiter"
 	"gorobei/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"testing"
+	"time"
 )
 
 //var re1 = regexp.MustCompile(`[_*[\]()~\x60>#+\-=]`)
@@ -43,3 +54,203 @@ another line
 	require.Equal(t, exp, EscapeMarkdown(input))
 }
 
+
+func Test_retryAfter(t *testing.T) {
+	err := fmt.Errorf("api: %w", &api.Error{ErrorCode: 429, Description: "Too Many Requests: retry after 7"})
+	require.Equal(t, 7*time.Second, retryAfter(err))
+	err = fmt.Errorf("api: %w", &api.Error{ErrorCode: 429, Parameters: &api.ResponseParameters{RetryAfter: 3}})
+	require.Equal(t, 3*time.Second, retryAfter(err))
+	err = fmt.Errorf("api: %w", &api.Error{ErrorCode: 400, Description: "Bad Request: chat not found"})
+	require.Equal(t, time.Duration(0), retryAfter(err))
+	require.Equal(t, time.Duration(0), retryAfter(errors.New("connection refused")))
+}
+
+func TestParseChat(t *testing.T) {
+	tests := []struct {
+		chat string
+		id   int64
+		name string
+	}{
+		{"-1001234567890", -1001234567890, ""},
+		{"12345", 12345, ""},
+		{"@Gorobei_Posts", 0, "@gorobei_posts"},
+		{"t.me/gorobei_posts", 0, "@gorobei_posts"},
+		{"https://t.me/gorobei_posts/123?single", 0, "@gorobei_posts"},
+		{"http://telegram.me/Gorobei_Posts", 0, "@gorobei_posts"},
+		{"https://t.me/c/1234567890/55", -1001234567890, ""},
+		{"John", 0, "john"},
+	}
+	for _, tt := range tests {
+		id, name, err := ParseChat(tt.chat)
+		require.NoError(t, err, tt.chat)
+		require.Equal(t, tt.id, id, tt.chat)
+		require.Equal(t, tt.name, name, tt.chat)
+	}
+	for _, chat := range []string{"", "https://t.me/+AbCdEf", "t.me/joinchat/AbCdEf", "t.me/c/", "t.me/c/name/5", "@", " @ "} {
+		_, _, err := ParseChat(chat)
+		require.Error(t, err, chat)
+	}
+}
+
+func Test_classifyChatError(t *testing.T) {
+	chatId := &telego.ChatID{ID: 5}
+	err := classifyChatError(chatId, fmt.Errorf("api: %w", &api.Error{ErrorCode: 400,
+		Description: "Bad Request: group chat was upgraded to a supergroup chat",
+		Parameters:  &api.ResponseParameters{MigrateToChatID: -1005}}))
+	var migrated *ChatMigratedError
+	require.ErrorAs(t, err, &migrated)
+	require.Equal(t, int64(5), migrated.From)
+	require.Equal(t, int64(-1005), migrated.To)
+
+	err = classifyChatError(chatId, fmt.Errorf("api: %w", &api.Error{ErrorCode: 403,
+		Description: "Forbidden: bot was kicked from the supergroup chat"}))
+	var unavailable *ChatUnavailableError
+	require.ErrorAs(t, err, &unavailable)
+	require.Equal(t, "bot was kicked", unavailable.Reason)
+	require.True(t, IsForbidden(err))
+
+	err = classifyChatError(chatId, fmt.Errorf("api: %w", &api.Error{ErrorCode: 400,
+		Description: "Bad Request: not enough rights to send photos to the chat"}))
+	require.ErrorAs(t, err, &unavailable)
+
+	plain := fmt.Errorf("api: %w", &api.Error{ErrorCode: 400, Description: "Bad Request: wrong file identifier"})
+	require.Equal(t, plain, classifyChatError(chatId, plain))
+}
+
+func TestTelegram_withRetry(t *testing.T) {
+	var slept []time.Duration
+	tg := &telegramImpl{
+		limiters: make(map[string]*limiter.RateLimiter),
+		global:   limiter.NewWindowLimiter(limiter.MaxBotRatePerSecond, time.Second),
+		sleep:    func(d time.Duration) { slept = append(slept, d) },
+	}
+	// do not wait for the chat limiter in the test
+	tg.limiters["1"] = limiter.NewLimiterWithRates(60, 1000)
+	calls := 0
+	err := tg.withRetry(&telego.ChatID{ID: 1}, func() error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("api: %w", &api.Error{ErrorCode: 429, Parameters: &api.ResponseParameters{RetryAfter: calls}})
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, slept)
+}
+
+func TestStripMarkdown(t *testing.T) {
+	input := EscapeMarkdown(utils.Bt(`*Bold* _text_ with [link](https://a.b/c?d=1) and ³code³.
+³³³
+stack\trace ³ here
+³³³
+end!`))
+	exp := utils.Bt(`Bold text with link (https://a.b/c?d=1) and code.
+stack\trace ³ here
+end!`)
+	require.Equal(t, exp, StripMarkdown(input))
+}
+
+func Test_parseEntitiesError(t *testing.T) {
+	err := fmt.Errorf("api: %w", &api.Error{ErrorCode: 400,
+		Description: "Bad Request: can't parse entities: Character '.' is reserved and must be escaped with the preceding '\\' (byte offset 12)"})
+	offset, ok := parseEntitiesError(err)
+	require.True(t, ok)
+	require.Equal(t, 12, offset)
+	_, ok = parseEntitiesError(fmt.Errorf("api: %w", &api.Error{ErrorCode: 400, Description: "Bad Request: chat not found"}))
+	require.False(t, ok)
+	require.Equal(t, "ab.cd", nearOffset("ab.cd", 2))
+}
+
+func TestTelegram_FakeServer(t *testing.T) {
+	const token = "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	var gotPath, gotText string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		var p struct {
+			Text string `json:"text"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		gotText = p.Text
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":42,"type":"private"}}}`))
+	}))
+	defer srv.Close()
+
+	tg, err := NewTelegram(&TelegramConfig{Token: token, ApiUrl: srv.URL + "/", Timeout: time.Second}, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, tg.SendMessageText("", 42, "hello"))
+	require.Equal(t, "/bot"+token+"/sendMessage", gotPath)
+	require.Equal(t, "hello", gotText)
+
+	_, err = NewTelegram(&TelegramConfig{Token: token, Proxy: "ftp://proxy"}, nil, nil)
+	require.Error(t, err)
+}
+
+func TestTelegramConfig_pollingTimeout(t *testing.T) {
+	require.Equal(t, longPollingTimeout, (&TelegramConfig{}).pollingTimeout())
+	require.Equal(t, 15, (&TelegramConfig{Timeout: 20 * time.Second}).pollingTimeout())
+}
+
+func TestTelegram_SendImageOptions(t *testing.T) {
+	const token = "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		form = r.MultipartForm.Value
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":3,"date":0,"chat":{"id":-100,"type":"supergroup"},` +
+			`"photo":[{"file_id":"small","file_unique_id":"s","width":1,"height":1},{"file_id":"big","file_unique_id":"b","width":2,"height":2}]}}`))
+	}))
+	defer srv.Close()
+
+	f, err := os.CreateTemp("", "*.jpg")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_ = f.Close()
+
+	tg, err := NewTelegram(&TelegramConfig{Token: token, ApiUrl: srv.URL}, nil, nil)
+	require.NoError(t, err)
+	opts := &PostOptions{ThreadId: 5, HasSpoiler: true, ProtectContent: true, SourceButton: "Source", sourceUrl: "http://page"}
+	sent, err := tg.SendImage("", -100, &Image{Path: f.Name()}, "caption", opts)
+	require.NoError(t, err)
+	require.Equal(t, &SentMessage{ChatId: -100, MessageId: 3, FileId: "big"}, sent)
+	require.Equal(t, []string{"5"}, form["message_thread_id"])
+	require.Equal(t, []string{"true"}, form["has_spoiler"])
+	require.Equal(t, []string{"true"}, form["protect_content"])
+	require.Equal(t, []string{"-100"}, form["chat_id"])
+	require.Equal(t, []string{`{"inline_keyboard":[[{"text":"Source","url":"http://page"}]]}`}, form["reply_markup"])
+}
+
+func Test_withReactions(t *testing.T) {
+	current := postMarkup(&PostOptions{Reactions: true, SourceButton: "Source", sourceUrl: "http://page"})
+	updated := withReactions(current, 3, 1)
+	require.Len(t, updated.InlineKeyboard, 2)
+	require.Equal(t, "👍 3", updated.InlineKeyboard[0][0].Text)
+	require.Equal(t, "http://page", updated.InlineKeyboard[1][0].URL)
+}
+
+func TestTelegram_storeUser(t *testing.T) {
+	d, f := openTestDb(t)
+	defer f()
+	tg := &telegramImpl{store: d}
+	msg := func(chat telego.Chat) *telego.Update {
+		return &telego.Update{Message: &telego.Message{Chat: chat, Date: 1000}}
+	}
+	require.NoError(t, tg.storeUser(msg(telego.Chat{ID: 1, Type: "private", Username: "John", FirstName: "J"})))
+	require.NoError(t, tg.storeUser(msg(telego.Chat{ID: 1, Type: "private", Username: "Johnny"})))
+	// no username
+	require.NoError(t, tg.storeUser(msg(telego.Chat{ID: 2, Type: "private"})))
+	// not a private chat
+	require.NoError(t, tg.storeUser(msg(telego.Chat{ID: -3, Type: "group", Username: "group"})))
+
+	_, err := d.ReadUserId("john")
+	require.ErrorIs(t, err, ErrNotFound)
+	id, err := d.ReadUserId("johnny")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), id)
+	_, err = d.ReadUser(2)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = d.ReadUserId("group")
+	require.ErrorIs(t, err, ErrNotFound)
+}
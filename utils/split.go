@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Telegram limits, the lengths are measured in UTF-16 code units.
+const (
+	MaxMessageLength = 4096
+	MaxCaptionLength = 1024
+)
+
+const fenceMark = "```"
+
+// Utf16Len returns the length of the string in UTF-16 code units.
+func Utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n += 1
+		}
+	}
+	return n
+}
+
+// SplitText splits a plain text message into chunks of at most limit UTF-16 code units.
+// Chunks are split at line breaks if possible, at spaces otherwise.
+func SplitText(s string, limit int) []string {
+	return split(s, limit, false)
+}
+
+// SplitMarkdown splits an escaped MarkdownV2 message into chunks of at most limit UTF-16 code units.
+// Escape sequences, inline entities and links are never cut in half. Code blocks are closed at the end of the
+// chunk and reopened at the beginning of the next one.
+func SplitMarkdown(s string, limit int) []string {
+	return split(s, limit, true)
+}
+
+type splitter struct {
+	limit    int
+	markdown bool
+	res      []string
+	chunk    strings.Builder
+	size     int
+	// fence is the opening line of the code block the chunk ends in
+	fence string
+	// reopened is the fence the chunk starts with if the code block continues from the previous chunk
+	reopened string
+}
+
+func split(s string, limit int, markdown bool) []string {
+	if Utf16Len(s) <= limit {
+		return []string{s}
+	}
+	sp := &splitter{limit: limit, markdown: markdown}
+	for _, line := range strings.SplitAfter(s, "\n") {
+		sp.addLine(line)
+	}
+	sp.flush()
+	return sp.res
+}
+
+// reserve is the room left for the closing fence
+func (sp *splitter) reserve(fence string) int {
+	if fence == "" {
+		return 0
+	}
+	return len("\n" + fenceMark)
+}
+
+func (sp *splitter) write(s string) {
+	sp.chunk.WriteString(s)
+	sp.size += Utf16Len(s)
+}
+
+func (sp *splitter) flush() {
+	chunk := sp.chunk.String()
+	if sp.blank() {
+		// the rest of the code block is white space
+		chunk = ""
+	} else if sp.fence != "" {
+		if strings.HasSuffix(chunk, sp.fence+"\n") {
+			// the code block has just been opened, move it to the next chunk
+			chunk = strings.TrimSuffix(chunk, sp.fence+"\n")
+		} else {
+			if !strings.HasSuffix(chunk, "\n") {
+				chunk += "\n"
+			}
+			chunk += fenceMark
+		}
+	}
+	chunk = strings.TrimRightFunc(chunk, unicode.IsSpace)
+	if chunk != "" {
+		sp.res = append(sp.res, chunk)
+	}
+	sp.chunk.Reset()
+	sp.size = 0
+	sp.reopened = sp.fence
+	if sp.fence != "" {
+		// reopen the code block
+		sp.write(sp.fence + "\n")
+	}
+}
+
+// blank checks if the chunk has no content except the reopened fence and white space
+func (sp *splitter) blank() bool {
+	return sp.reopened != "" && strings.TrimSpace(strings.TrimPrefix(sp.chunk.String(), sp.reopened)) == ""
+}
+
+// empty checks if the chunk has no content except the reopened fence
+func (sp *splitter) empty() bool {
+	return sp.size == 0 || (sp.fence != "" && sp.chunk.String() == sp.fence+"\n")
+}
+
+func (sp *splitter) addLine(line string) {
+	fenceAfter := sp.fence
+	if sp.markdown && strings.HasPrefix(line, fenceMark) {
+		if sp.fence == "" {
+			fenceAfter = strings.TrimRight(line, "\n")
+		} else {
+			fenceAfter = ""
+		}
+	}
+	if sp.fence != "" && fenceAfter == "" && sp.blank() {
+		// the code block is closed right after it has been reopened, drop it
+		sp.chunk.Reset()
+		sp.size = 0
+		sp.reopened = ""
+		sp.fence = ""
+		return
+	}
+	size := Utf16Len(line)
+	if sp.size+size+sp.reserve(fenceAfter) > sp.limit && !sp.empty() {
+		sp.flush()
+	}
+	for sp.size+Utf16Len(line)+sp.reserve(fenceAfter) > sp.limit {
+		// the line doesn't fit into the empty chunk
+		budget := sp.limit - sp.size - sp.reserve(sp.fence)
+		cut := cutPoint(line, budget, sp.markdown, sp.fence != "")
+		sp.write(line[:cut])
+		line = line[cut:]
+		sp.flush()
+	}
+	sp.write(line)
+	sp.fence = fenceAfter
+}
+
+// cutPoint returns the byte index the line can be cut at, so the first part fits into budget.
+// Markdown lines are not cut inside escape sequences and entities, the lines of code blocks have no entities.
+func cutPoint(line string, budget int, markdown bool, code bool) int {
+	var (
+		size, lastSafe, lastSpace int
+		escaped, inLink, inUrl    bool
+		marks                     = map[rune]int{}
+	)
+	safe := func() bool {
+		if escaped {
+			return false
+		}
+		if !markdown || code {
+			return true
+		}
+		if inLink || marks['`']%2 != 0 {
+			return false
+		}
+		return marks['*']%2 == 0 && marks['_']%2 == 0 && marks['~']%2 == 0 && marks['|']%2 == 0
+	}
+	prev := rune(0)
+	for i, r := range line {
+		if i > 0 && safe() {
+			lastSafe = i
+			if unicode.IsSpace(prev) {
+				lastSpace = i
+			}
+		}
+		size += Utf16Len(string(r))
+		if size > budget {
+			break
+		}
+		switch {
+		case !markdown:
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case code:
+		case marks['`']%2 != 0 && r != '`':
+			// inside inline code
+		case r == '[':
+			inLink = true
+		case r == '(' && inLink && prev == ']':
+			inUrl = true
+		case r == ')' && inUrl:
+			inLink, inUrl = false, false
+		case r == '`' || r == '*' || r == '_' || r == '~' || r == '|':
+			marks[r]++
+		}
+		prev = r
+	}
+	switch {
+	case lastSpace > 0:
+		return lastSpace
+	case lastSafe > 0:
+		return lastSafe
+	}
+	// no safe place, cut by the budget
+	size = 0
+	for i, r := range line {
+		size += Utf16Len(string(r))
+		if size > budget && i > 0 {
+			return i
+		}
+	}
+	_, n := utf8.DecodeRuneInString(line)
+	return n
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestSplitText(t *testing.T) {
+	require.Equal(t, []string{"short"}, SplitText("short", 10))
+	require.Equal(t, []string{"line one", "line two"}, SplitText("line one\nline two", 10))
+	require.Equal(t, []string{"aaaa bbbb", "cccc"}, SplitText("aaaa bbbb cccc", 10))
+	require.Equal(t, []string{"aaaaa", "aaaaa", "aa"}, SplitText("aaaaaaaaaaaa", 5))
+	// emojis take 2 UTF-16 code units
+	require.Equal(t, []string{"😀😀", "😀"}, SplitText("😀😀😀", 4))
+}
+
+func TestSplitMarkdown(t *testing.T) {
+	in := Bt("header\n³³³go\nline 1\nline 2\nline 3\n³³³\nfooter")
+	out := SplitMarkdown(in, 24)
+	require.Equal(t, []string{
+		Bt("header\n³³³go\nline 1\n³³³"),
+		Bt("³³³go\nline 2\nline 3\n³³³"),
+		"footer",
+	}, out)
+	for _, c := range out {
+		require.LessOrEqual(t, Utf16Len(c), 24)
+	}
+	// the code block isn't left empty at the end of the chunk
+	require.Equal(t, []string{"header", Bt("³³³\nline 1\n³³³")}, SplitMarkdown(Bt("header\n³³³\nline 1\n³³³"), 15))
+	// the code block is not left empty when only the line break remains of the cut line
+	require.Equal(t, []string{Bt("³³³go\naaaa bbbb \n³³³"), "next"}, SplitMarkdown(Bt("³³³go\naaaa bbbb \n³³³\nnext"), 20))
+	require.Equal(t, []string{Bt("³³³go\naaaa bbbb \n³³³")}, SplitMarkdown(Bt("³³³go\naaaa bbbb \n\n\n\n\n"), 20))
+}
+
+func TestSplitMarkdownEntities(t *testing.T) {
+	// escape sequences are not cut
+	require.Equal(t, []string{"ab", `\.c`, "def"}, SplitMarkdown(`ab\.cdef`, 3))
+	// inline code and links are kept whole
+	require.Equal(t, []string{"aa", Bt("³b c³ dd")}, SplitMarkdown(Bt("aa ³b c³ dd"), 8))
+	require.Equal(t, []string{"go", "[x y](http://a) z"}, SplitMarkdown("go [x y](http://a) z", 17))
+	require.Equal(t, []string{"*bold text*", "plain"}, SplitMarkdown("*bold text* plain", 12))
+}
+
+func TestSplitMarkdownCodeEscapes(t *testing.T) {
+	// the escape sequences of the code block are not cut, the entity marks are plain text there
+	line := strings.Repeat(`ab\³`, 20)
+	out := SplitMarkdown(Bt("³³³\n"+line+"\n³³³"), 31)
+	require.Greater(t, len(out), 1)
+	var code string
+	for _, c := range out {
+		require.LessOrEqual(t, Utf16Len(c), 31)
+		require.True(t, strings.HasPrefix(c, Bt("³³³\n")) && strings.HasSuffix(c, Bt("\n³³³")), c)
+		body := strings.TrimSuffix(strings.TrimPrefix(c, Bt("³³³\n")), Bt("\n³³³"))
+		// every backslash stays with the backtick it escapes
+		require.Equal(t, strings.Count(body, `\`), strings.Count(body, Bt(`\³`)), c)
+		code += body
+	}
+	require.Equal(t, Bt(line), code)
+	require.Equal(t, []string{Bt("³³³\n*a\n³³³"), Bt("³³³\n" + `\³` + "b\n³³³"), Bt("³³³\n_c\n³³³")},
+		SplitMarkdown(Bt("³³³\n*a"+`\³`+"b_c\n³³³"), 11))
+}
+
+func TestSplitLongMessage(t *testing.T) {
+	in := strings.Repeat("0123456789 ", 1000)
+	out := SplitText(in, MaxMessageLength)
+	require.Len(t, out, 3)
+	require.Equal(t, strings.ReplaceAll(in, " ", ""), strings.ReplaceAll(strings.Join(out, ""), " ", ""))
+}